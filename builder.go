@@ -0,0 +1,182 @@
+package testmark
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// DocumentBuilder produces a Document from scratch, piece by piece.
+// This is useful when generating documents from code (e.g. conformance fixtures),
+// where you still want headings and prose around the hunks, so the result is readable.
+//
+// Each of the Heading, Paragraph, and Hunk methods adds a markdown block,
+// and blocks are separated from each other by a blank line.
+// Raw adds lines exactly as given, with no separation.
+//
+// The methods return the builder, so they can be chained.
+// Any problem (such as a bad or repeated hunk name) is remembered, and returned when Build is called;
+// everything after the first problem is ignored.
+//
+// The Document produced is the same as what Parse would produce from its serial form
+// (except that Original is nil), so it's ready for Write, and also for Patch.
+type DocumentBuilder struct {
+	doc Document
+	err error
+}
+
+func NewDocumentBuilder() *DocumentBuilder {
+	return &DocumentBuilder{doc: Document{
+		HunksByName: make(map[string]DocHunk),
+	}}
+}
+
+// Heading adds an ATX-style heading (e.g. "## text" for level 2).
+// Levels less than 1 or more than 6 are an error.
+func (b *DocumentBuilder) Heading(level int, text string) *DocumentBuilder {
+	if b.err != nil {
+		return b
+	}
+	if level < 1 || level > 6 {
+		b.err = fmt.Errorf("heading level must be between 1 and 6, not %d", level)
+		return b
+	}
+	if strings.ContainsRune(text, '\n') {
+		b.err = fmt.Errorf("heading text cannot contain linebreaks")
+		return b
+	}
+	b.separate()
+	b.doc.Lines = append(b.doc.Lines, []byte(strings.Repeat("#", level)+" "+text))
+	return b
+}
+
+// Paragraph adds some prose.  The text may contain linebreaks.
+// (Nothing is escaped; the text is markdown, and can use whatever markdown features you like.)
+func (b *DocumentBuilder) Paragraph(text string) *DocumentBuilder {
+	if b.err != nil {
+		return b
+	}
+	b.separate()
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if err := b.checkProse(line); err != nil {
+			b.err = err
+			return b
+		}
+		b.doc.Lines = append(b.doc.Lines, []byte(line))
+	}
+	return b
+}
+
+// Hunk adds a testmark data hunk: the comment line, and the code block.
+//
+// As with Parse, the hunk's Body in the built document always ends in a linebreak (unless it's empty),
+// because there's no way to describe data without a trailing linebreak in a markdown code block.
+// The body can't contain any lines which start with triple-backticks, since they'd end the code block early.
+func (b *DocumentBuilder) Hunk(name string, info string, body []byte) *DocumentBuilder {
+	if b.err != nil {
+		return b
+	}
+	if name == "" || bytes.IndexFunc([]byte(name), unicode.IsSpace) >= 0 {
+		b.err = fmt.Errorf("hunk name %q is invalid: must not be empty and cannot contain whitespace", name)
+		return b
+	}
+	if already, exists := b.doc.HunksByName[name]; exists {
+		b.err = fmt.Errorf("repeated testmark hunk name %q, first added on line %d", name, already.LineStart+1)
+		return b
+	}
+	if strings.ContainsRune(info, '\n') {
+		b.err = fmt.Errorf("info string for hunk %q cannot contain linebreaks", name)
+		return b
+	}
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		body = append(append([]byte(nil), body...), '\n')
+	}
+	bodyLines := splitBodyLines(body)
+	for i, line := range bodyLines {
+		if bytes.HasPrefix(line, sigilCodeBlock) {
+			b.err = fmt.Errorf("body of hunk %q cannot be described in markdown: line %d starts with a code block indicator", name, i+1)
+			return b
+		}
+	}
+
+	b.separate()
+	lineStart := len(b.doc.Lines)
+	b.doc.Lines = appendHunkLines(b.doc.Lines, name, info, bodyLines)
+	hunk := DocHunk{
+		LineStart: lineStart,
+		LineEnd:   len(b.doc.Lines) - 1,
+		Hunk: Hunk{
+			Name:       name,
+			InfoString: info,
+			Body:       body,
+		},
+	}
+	b.doc.DataHunks = append(b.doc.DataHunks, hunk)
+	b.doc.HunksByName[name] = hunk
+	return b
+}
+
+// Raw adds lines exactly as given, with no blank line before them.
+// Lines shouldn't contain linebreaks.
+//
+// Raw lines can't open code blocks or contain testmark comments,
+// because the builder wouldn't be keeping track of them; use Hunk for those.
+// (A plain code block that isn't testmark data can still be written with Raw,
+// so long as the opening and closing lines are both given in the same call.)
+func (b *DocumentBuilder) Raw(lines ...string) *DocumentBuilder {
+	if b.err != nil {
+		return b
+	}
+	inCodeBlock := false
+	for _, line := range lines {
+		if strings.ContainsRune(line, '\n') {
+			b.err = fmt.Errorf("raw lines cannot contain linebreaks")
+			return b
+		}
+		if strings.HasPrefix(line, string(sigilCodeBlock)) {
+			inCodeBlock = !inCodeBlock
+		} else if !inCodeBlock {
+			if err := b.checkProse(line); err != nil {
+				b.err = err
+				return b
+			}
+		}
+	}
+	if inCodeBlock {
+		b.err = fmt.Errorf("raw lines opened a code block without closing it")
+		return b
+	}
+	for _, line := range lines {
+		b.doc.Lines = append(b.doc.Lines, []byte(line))
+	}
+	return b
+}
+
+// Build returns the finished Document, or the first error encountered while building.
+// The builder shouldn't be used again afterwards.
+func (b *DocumentBuilder) Build() (*Document, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	doc := b.doc
+	return &doc, nil
+}
+
+// separate appends a blank line, if there's anything before this and it isn't already blank.
+func (b *DocumentBuilder) separate() {
+	if l := len(b.doc.Lines); l > 0 && len(b.doc.Lines[l-1]) > 0 {
+		b.doc.Lines = append(b.doc.Lines, []byte{})
+	}
+}
+
+// checkProse rejects prose lines that would change the meaning of the document when parsed.
+func (b *DocumentBuilder) checkProse(line string) error {
+	if strings.HasPrefix(line, string(sigilCodeBlock)) {
+		return fmt.Errorf("prose line %q starts a code block; use Hunk (or Raw with the whole code block) instead", line)
+	}
+	if strings.HasPrefix(line, string(sigilTestmark)) {
+		return fmt.Errorf("prose line %q is a testmark comment; use Hunk instead", line)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package testmark_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/warpfork/go-testmark"
+)
+
+func TestDocumentBuilder(t *testing.T) {
+	doc, err := testmark.NewDocumentBuilder().
+		Heading(1, "conformance fixtures").
+		Paragraph("These are generated.\nDon't edit them by hand.").
+		Heading(2, "first case").
+		Hunk("first/input", "json", []byte(`{"a": 1}`)).
+		Hunk("first/output", "", []byte("ok\n")).
+		Raw("", "```", "an ordinary code block", "```").
+		Hunk("empty", "", nil).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, doc.String(), "# conformance fixtures\n"+
+		"\n"+
+		"These are generated.\n"+
+		"Don't edit them by hand.\n"+
+		"\n"+
+		"## first case\n"+
+		"\n"+
+		"[testmark]:# (first/input)\n"+
+		"```json\n"+
+		"{\"a\": 1}\n"+
+		"```\n"+
+		"\n"+
+		"[testmark]:# (first/output)\n"+
+		"```\n"+
+		"ok\n"+
+		"```\n"+
+		"\n"+
+		"```\n"+
+		"an ordinary code block\n"+
+		"```\n"+
+		"\n"+
+		"[testmark]:# (empty)\n"+
+		"```\n"+
+		"```\n")
+
+	// The built document should agree exactly with what parsing its serial form gives.
+	reparsed, err := testmark.Parse([]byte(doc.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reparsed.DataHunks) != len(doc.DataHunks) {
+		t.Fatalf("reparse found %d hunks; builder made %d", len(reparsed.DataHunks), len(doc.DataHunks))
+	}
+	for i := range doc.DataHunks {
+		assert(t, doc.DataHunks[i], fmt.Sprintf("%v", reparsed.DataHunks[i]))
+	}
+
+	// And it should be patchable.
+	doc = testmark.Patch(doc, testmark.Hunk{Name: "first/output", Body: []byte("still ok\n")})
+	reparsed, err = testmark.Parse([]byte(doc.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, reparsed.HunksByName["first/output"].Body, "still ok\n")
+	assert(t, reparsed.HunksByName["empty"].LineStart+1, "22")
+}
+
+func TestDocumentBuilderErrors(t *testing.T) {
+	_, err := testmark.NewDocumentBuilder().Hunk("a", "", nil).Hunk("a", "", nil).Build()
+	assert(t, err, `repeated testmark hunk name "a", first added on line 1`)
+
+	_, err = testmark.NewDocumentBuilder().Hunk("has space", "", nil).Build()
+	assert(t, err, `hunk name "has space" is invalid: must not be empty and cannot contain whitespace`)
+
+	_, err = testmark.NewDocumentBuilder().Hunk("fence", "", []byte("```\n")).Build()
+	assert(t, err, `body of hunk "fence" cannot be described in markdown: line 1 starts with a code block indicator`)
+
+	_, err = testmark.NewDocumentBuilder().Paragraph("[testmark]:# (sneaky)").Build()
+	assert(t, err, `prose line "[testmark]:# (sneaky)" is a testmark comment; use Hunk instead`)
+}
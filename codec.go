@@ -0,0 +1,157 @@
+package testmark
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Codec describes how to decode (and encode) hunk bodies of some data format.
+// Codecs are registered by info string with RegisterCodec,
+// and then Hunk.Decode and EncodeHunk will pick the right one based on a hunk's InfoString.
+//
+// Only a few codecs are built in (JSON and CSV), because testmark's core doesn't take on any dependencies.
+// Other formats are easy to plug in.  For example, YAML (with `gopkg.in/yaml.v3`) can be registered like this:
+//
+//	testmark.RegisterCodec("yaml", testmark.Codec{
+//		Decode: func(body []byte, v interface{}, strict bool) error {
+//			dec := yaml.NewDecoder(bytes.NewReader(body))
+//			dec.KnownFields(strict)
+//			return dec.Decode(v)
+//		},
+//		Encode: yaml.Marshal,
+//	})
+//
+// ... and TOML (with `github.com/pelletier/go-toml/v2`) like this:
+//
+//	testmark.RegisterCodec("toml", testmark.Codec{
+//		Decode: func(body []byte, v interface{}, strict bool) error {
+//			dec := toml.NewDecoder(bytes.NewReader(body))
+//			if strict {
+//				dec.DisallowUnknownFields()
+//			}
+//			return dec.Decode(v)
+//		},
+//		Encode: toml.Marshal,
+//	})
+type Codec struct {
+	// Decode parses the body into v (which is typically a pointer).
+	// If strict is true, data that doesn't fit into v (e.g. unknown fields) should be rejected rather than ignored.
+	Decode func(body []byte, v interface{}, strict bool) error
+
+	// Encode serializes v.  The output should be stable (e.g. map keys sorted),
+	// since it's typically used to regenerate fixtures that are checked into version control.
+	// May be nil, if the codec only supports decoding.
+	Encode func(v interface{}) ([]byte, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		"json": {Decode: decodeJSON, Encode: encodeJSON},
+		"csv":  {Decode: decodeCSV, Encode: encodeCSV},
+	}
+)
+
+// RegisterCodec associates a Codec with an info string.
+// Registering a codec for an info string that already has one replaces it.
+// The info string is matched case-insensitively.
+//
+// It's typical to call this from an init function, or TestMain, in test code.
+func RegisterCodec(info string, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[strings.ToLower(info)] = codec
+}
+
+// LookupCodec returns the Codec registered for an info string.
+// Only the first word of the info string is considered
+// (markdown allows more things after the language name, e.g. "json title=foo"),
+// and case doesn't matter.
+func LookupCodec(info string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[codecKey(info)]
+	return codec, ok
+}
+
+func codecKey(info string) string {
+	fields := strings.Fields(info)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToLower(fields[0])
+}
+
+// Decode parses the hunk body into v, using the codec registered for the hunk's InfoString.
+// It's an error if there's no codec for that info string (including if the info string is empty).
+//
+// Data that doesn't fit into v (like unknown fields in a struct) is ignored; use DecodeStrict to reject it instead.
+func (h Hunk) Decode(v interface{}) error {
+	return h.decode(v, false)
+}
+
+// DecodeStrict is like Decode, but data that doesn't fit into v (like unknown fields in a struct) is an error.
+func (h Hunk) DecodeStrict(v interface{}) error {
+	return h.decode(v, true)
+}
+
+func (h Hunk) decode(v interface{}, strict bool) error {
+	codec, ok := LookupCodec(h.InfoString)
+	if !ok {
+		return fmt.Errorf("cannot decode hunk %q: no codec registered for info string %q", h.Name, h.InfoString)
+	}
+	if err := codec.Decode(h.Body, v, strict); err != nil {
+		return fmt.Errorf("cannot decode hunk %q as %s: %w", h.Name, codecKey(h.InfoString), err)
+	}
+	return nil
+}
+
+// EncodeHunk serializes v using the codec registered for the info string, and returns a Hunk containing it,
+// ready for use with Patch (or PatchAccumulator).
+// The body always ends with a linebreak, as it would after being parsed.
+func EncodeHunk(name string, info string, v interface{}) (Hunk, error) {
+	codec, ok := LookupCodec(info)
+	if !ok {
+		return Hunk{}, fmt.Errorf("cannot encode hunk %q: no codec registered for info string %q", name, info)
+	}
+	if codec.Encode == nil {
+		return Hunk{}, fmt.Errorf("cannot encode hunk %q: codec for info string %q only supports decoding", name, info)
+	}
+	body, err := codec.Encode(v)
+	if err != nil {
+		return Hunk{}, fmt.Errorf("cannot encode hunk %q as %s: %w", name, codecKey(info), err)
+	}
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		body = append(body, '\n')
+	}
+	return Hunk{Name: name, InfoString: info, Body: body}, nil
+}
+
+func decodeJSON(body []byte, v interface{}, strict bool) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if strict {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after the first value")
+	}
+	return nil
+}
+
+func encodeJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,214 @@
+package testmark
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// The CSV codec supports a few shapes of value:
+//
+//   - *[][]string -- every record, as-is (including any header row).
+//   - *[]map[string]string -- the first record is a header row, and each following record becomes a map.
+//   - *interface{} -- every record, as-is, as a []interface{} of rows, each a []interface{} of strings.
+//     (This is what generic consumers, like the compare package, get.)
+//   - *[]SomeStruct -- the first record is a header row, and each following record fills in a struct.
+//     Columns are matched to fields by a `csv:"name"` tag, or else the field name.
+//     Fields can be strings, bools, or any int, uint, or float kind.
+//
+// In strict mode, a header column that doesn't match any struct field is an error.
+// (Records always have to have the same number of fields as the first one.)
+
+func decodeCSV(body []byte, v interface{}, strict bool) error {
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		return err
+	}
+	switch out := v.(type) {
+	case *[][]string:
+		*out = records
+		return nil
+	case *interface{}:
+		rows := make([]interface{}, len(records))
+		for i, record := range records {
+			row := make([]interface{}, len(record))
+			for j, cell := range record {
+				row[j] = cell
+			}
+			rows[i] = row
+		}
+		*out = rows
+		return nil
+	case *[]map[string]string:
+		if len(records) == 0 {
+			*out = nil
+			return nil
+		}
+		header := records[0]
+		result := make([]map[string]string, 0, len(records)-1)
+		for _, record := range records[1:] {
+			row := make(map[string]string, len(header))
+			for i, col := range header {
+				row[col] = record[i]
+			}
+			result = append(result, row)
+		}
+		*out = result
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Slice || rv.Elem().Type().Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cannot decode CSV into %T (need *[][]string, *[]map[string]string, *interface{}, or a pointer to a slice of structs)", v)
+	}
+	slice := rv.Elem()
+	slice.Set(reflect.MakeSlice(slice.Type(), 0, len(records)))
+	if len(records) == 0 {
+		return nil
+	}
+	elemType := slice.Type().Elem()
+	fieldsByColumn := csvFields(elemType)
+	header := records[0]
+	columns := make([]int, len(header)) // field index for each column, or -1.
+	for i, col := range header {
+		idx, ok := fieldsByColumn[col]
+		if !ok {
+			if strict {
+				return fmt.Errorf("unknown column %q (no field in %s matches it)", col, elemType)
+			}
+			idx = -1
+		}
+		columns[i] = idx
+	}
+	for line, record := range records[1:] {
+		elem := reflect.New(elemType).Elem()
+		for i, cell := range record {
+			if columns[i] < 0 {
+				continue
+			}
+			if err := setCSVField(elem.Field(columns[i]), cell); err != nil {
+				return fmt.Errorf("record %d, column %q: %w", line+2, header[i], err)
+			}
+		}
+		slice.Set(reflect.Append(slice, elem))
+	}
+	return nil
+}
+
+func encodeCSV(v interface{}) ([]byte, error) {
+	var records [][]string
+	switch in := v.(type) {
+	case [][]string:
+		records = in
+	case []map[string]string:
+		// Columns are the union of all the keys, sorted.
+		cols := map[string]struct{}{}
+		for _, row := range in {
+			for k := range row {
+				cols[k] = struct{}{}
+			}
+		}
+		header := make([]string, 0, len(cols))
+		for k := range cols {
+			header = append(header, k)
+		}
+		sort.Strings(header)
+		records = append(records, header)
+		for _, row := range in {
+			record := make([]string, len(header))
+			for i, col := range header {
+				record[i] = row[col]
+			}
+			records = append(records, record)
+		}
+	default:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() != reflect.Struct {
+			return nil, fmt.Errorf("cannot encode %T as CSV (need [][]string, []map[string]string, or a slice of structs)", v)
+		}
+		elemType := rv.Type().Elem()
+		var header []string
+		var indexes []int
+		for i := 0; i < elemType.NumField(); i++ {
+			if name, ok := csvFieldName(elemType.Field(i)); ok {
+				header = append(header, name)
+				indexes = append(indexes, i)
+			}
+		}
+		records = append(records, header)
+		for i := 0; i < rv.Len(); i++ {
+			record := make([]string, len(indexes))
+			for j, idx := range indexes {
+				record[j] = fmt.Sprint(rv.Index(i).Field(idx).Interface())
+			}
+			records = append(records, record)
+		}
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(records); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func csvFields(rt reflect.Type) map[string]int {
+	fields := make(map[string]int)
+	for i := 0; i < rt.NumField(); i++ {
+		if name, ok := csvFieldName(rt.Field(i)); ok {
+			fields[name] = i
+		}
+	}
+	return fields
+}
+
+func csvFieldName(sf reflect.StructField) (string, bool) {
+	if sf.PkgPath != "" { // unexported
+		return "", false
+	}
+	tag := sf.Tag.Get("csv")
+	if tag == "-" {
+		return "", false
+	}
+	if tag != "" {
+		return tag, true
+	}
+	return sf.Name, true
+}
+
+func setCSVField(fv reflect.Value, cell string) error {
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(cell)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(cell)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(cell, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(cell, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(cell, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(n)
+	default:
+		return fmt.Errorf("cannot decode a CSV field into %s", fv.Type())
+	}
+	return nil
+}
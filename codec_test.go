@@ -0,0 +1,75 @@
+package testmark_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/warpfork/go-testmark"
+)
+
+func TestHunkDecode(t *testing.T) {
+	type thing struct {
+		Name  string `json:"name" csv:"name"`
+		Count int    `json:"count" csv:"count"`
+	}
+
+	t.Run("json", func(t *testing.T) {
+		hunk := testmark.Hunk{Name: "x", InfoString: "json", Body: []byte(`{"name": "a", "count": 2, "extra": true}` + "\n")}
+		var v thing
+		if err := hunk.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		assert(t, fmt.Sprintf("%+v", v), "{Name:a Count:2}")
+		err := hunk.DecodeStrict(&v)
+		assert(t, err, `cannot decode hunk "x" as json: json: unknown field "extra"`)
+	})
+	t.Run("csv", func(t *testing.T) {
+		hunk := testmark.Hunk{Name: "x", InfoString: "CSV", Body: []byte("name,count,extra\na,1,z\nb,2,z\n")}
+		var v []thing
+		if err := hunk.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		assert(t, fmt.Sprintf("%+v", v), "[{Name:a Count:1} {Name:b Count:2}]")
+		err := hunk.DecodeStrict(&v)
+		assert(t, err, `cannot decode hunk "x" as csv: unknown column "extra" (no field in testmark_test.thing matches it)`)
+		var rows []map[string]string
+		if err := hunk.Decode(&rows); err != nil {
+			t.Fatal(err)
+		}
+		assert(t, fmt.Sprintf("%v", rows), "[map[count:1 extra:z name:a] map[count:2 extra:z name:b]]")
+	})
+	t.Run("unknown", func(t *testing.T) {
+		err := testmark.Hunk{Name: "x", InfoString: "text"}.Decode(&struct{}{})
+		assert(t, err, `cannot decode hunk "x": no codec registered for info string "text"`)
+	})
+	t.Run("custom", func(t *testing.T) {
+		testmark.RegisterCodec("lines", testmark.Codec{
+			Decode: func(body []byte, v interface{}, strict bool) error {
+				*(v.(*[]string)) = strings.Fields(string(body))
+				return nil
+			},
+		})
+		var v []string
+		if err := (testmark.Hunk{Name: "x", InfoString: "lines extra-words", Body: []byte("a\nb\n")}).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		assert(t, fmt.Sprintf("%v", v), "[a b]")
+		_, err := testmark.EncodeHunk("x", "lines", v)
+		assert(t, err, `cannot encode hunk "x": codec for info string "lines" only supports decoding`)
+	})
+}
+
+func TestEncodeHunk(t *testing.T) {
+	hunk, err := testmark.EncodeHunk("out", "json", map[string]interface{}{"z": 1, "a": []string{"<b>"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, hunk.Body, "{\n\t\"a\": [\n\t\t\"<b>\"\n\t],\n\t\"z\": 1\n}\n")
+
+	hunk, err = testmark.EncodeHunk("out", "csv", []map[string]string{{"b": "2", "a": "1"}, {"a": "x,y"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert(t, hunk.Body, "a,b\n1,2\n\"x,y\",\n")
+}
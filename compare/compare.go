@@ -0,0 +1,98 @@
+/*
+compare is a package of comparators for checking actual output against expected data hunks,
+which understand what kind of content they're looking at.
+For example, JSON is compared by structure (so key order and indentation don't matter),
+and a hunk tagged as a set of lines doesn't care what order the lines come in.
+
+The comparator is chosen by the expected hunk's info string (see For).
+Each difference is reported with a path to where it was found, like `$.items[3].name`, or `line 4`.
+
+Use AssertHunk directly in your own tests or suite functors,
+or plug it into `testexec.Tester` as the AssertHunkFn.
+
+This package isn't part of testmark's core.
+It has no dependencies beyond testmark's core; formats other than JSON are supported by way of
+testmark's codec registry (see testmark.RegisterCodec).
+*/
+package compare
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/warpfork/go-testmark"
+)
+
+// Difference is one place where actual content didn't match what was expected.
+type Difference struct {
+	// Where the difference was found.
+	// For structured data, this is a path like `$.items[3].name` (with `$` being the root of the document).
+	// For text, it's usually a line number, like `line 4`.
+	Path string
+
+	// What was wrong there, in prose.
+	Message string
+}
+
+func (d Difference) String() string {
+	return d.Path + ": " + d.Message
+}
+
+// Comparator checks actual content against expected content, and returns any differences.
+// An empty result means they match.
+//
+// Content that can't be parsed (e.g. invalid JSON) is reported as a difference too.
+type Comparator func(actual, expect []byte) []Difference
+
+// For picks a comparator based on an info string.
+//
+// The first word of the info string picks the kind of comparison:
+//
+//   - "json" -- structural comparison of JSON (key order and whitespace don't matter).
+//   - "lines" or "lineset" -- the same lines must be present, in any order (see LineSet).
+//   - "numbers" -- whitespace-separated numbers, compared within a tolerance (see Numbers).
+//   - anything else that has a codec registered with testmark.RegisterCodec (e.g. "yaml", if you've registered one) --
+//     structural comparison, after decoding with that codec (see Structured).
+//   - anything else at all, including an empty info string -- exact comparison (see Exact).
+//
+// Further words in the info string can set options.
+// Currently the only one is "tolerance=", which sets the tolerance for comparing floating point numbers,
+// and applies to both "numbers" and any structural comparison.
+// For example: ```` ```json tolerance=0.001 ````.
+func For(info string) Comparator {
+	fields := strings.Fields(info)
+	if len(fields) == 0 {
+		return Exact()
+	}
+	var tolerance float64
+	for _, opt := range fields[1:] {
+		if strings.HasPrefix(opt, "tolerance=") {
+			if tol, err := strconv.ParseFloat(opt[len("tolerance="):], 64); err == nil {
+				tolerance = tol
+			}
+		}
+	}
+	switch kind := strings.ToLower(fields[0]); kind {
+	case "lines", "lineset":
+		return LineSet()
+	case "numbers":
+		return Numbers(tolerance)
+	default:
+		if _, ok := testmark.LookupCodec(kind); ok {
+			return Structured(kind, tolerance)
+		}
+		return Exact()
+	}
+}
+
+// AssertHunk compares actual content against an expected hunk, using the comparator chosen by the hunk's info string (see For),
+// and reports each difference with `t.Errorf`.
+//
+// This function matches the signature of `testexec.AssertHunkFn`, so it can be used directly in a `testexec.Tester`.
+func AssertHunk(t *testing.T, actual string, expect testmark.Hunk) {
+	t.Helper()
+	for _, diff := range For(expect.InfoString)([]byte(actual), expect.Body) {
+		t.Errorf("hunk %q: %s", expect.Name, diff)
+	}
+}
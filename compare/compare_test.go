@@ -0,0 +1,38 @@
+package compare_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/warpfork/go-testmark/compare"
+)
+
+func TestFor(t *testing.T) {
+	for _, tc := range []struct {
+		info   string
+		actual string
+		expect string
+		diffs  string
+	}{
+		{"", "a\nb\n", "a\nb\n", "[]"},
+		{"", "a\nc\n", "a\nb\n", `[line 2: expected "b"; actual "c"]`},
+		{"text", "a\n", "a\nb\n", `[line 2: expected "b"; actual ""]`}, // Unknown info strings fall back to exact comparison.
+		{"json", `{"b": [1, 2.0], "a": "x"}`, "{\n\t\"a\": \"x\",\n\t\"b\": [1, 2]\n}\n", "[]"},
+		{"json", `{"items": [{}, {}, {}, {"name": "b", "extra": 1}]}`, `{"items": [{}, {}, {}, {"name": "a"}], "gone": null}`,
+			`[$.gone: expected key is missing $.items[3].extra: unexpected key $.items[3].name: expected "a"; actual "b"]`},
+		{"json", `{"odd key": [1]}`, `{"odd key": [1, true]}`, `[$["odd key"][1]: expected true; actual list ended]`},
+		{"json", `{"x": 1.0001}`, `{"x": 1}`, `[$.x: expected 1; actual 1.0001]`},
+		{"json tolerance=0.001", `{"x": 1.0001}`, `{"x": 1}`, "[]"},
+		{"json", `{`, `{}`, `[$: actual content could not be parsed as json: unexpected EOF]`},
+		{"csv", "name,count\na,1\n", "name,count\na,1\n", "[]"},
+		{"csv", "name,count\n\"a\",2\n", "name,count\na,1\n", `[$[1][1]: expected "1"; actual "2"]`},
+		{"lines", "b\na\na\n", "a\nb\na\n", "[]"},
+		{"lineset", "b\nc\n", "a\nb\n", `[actual line 2: unexpected line "c" line 1: expected line "a" is missing]`},
+		{"numbers tolerance=0.01", "1.001 2\n", "1 2.5\n", `[[1]: expected 2.5; actual 2 (tolerance 0.01)]`},
+	} {
+		diffs := compare.For(tc.info)([]byte(tc.actual), []byte(tc.expect))
+		if fmt.Sprintf("%v", diffs) != tc.diffs {
+			t.Errorf("info %q, actual %q, expect %q:\n\texpected differences: %s\n\tactual differences:   %v", tc.info, tc.actual, tc.expect, tc.diffs, diffs)
+		}
+	}
+}
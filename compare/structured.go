@@ -0,0 +1,228 @@
+package compare
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/warpfork/go-testmark"
+)
+
+// JSON returns a comparator that parses both sides as JSON and compares them structurally.
+// Key order and whitespace don't matter.
+// Numbers are compared exactly, unless a tolerance is given, in which case they're compared as floats within it.
+func JSON(tolerance float64) Comparator {
+	return Structured("json", tolerance)
+}
+
+// Structured returns a comparator that decodes both sides with the testmark codec registered for the given info string
+// (see testmark.RegisterCodec), and compares the resulting values structurally.
+//
+// Maps are compared by key (order doesn't matter); lists are compared in order.
+// Numbers of any type are compared by value; if the tolerance is non-zero, floats within it are considered equal.
+//
+// The codec should decode into a plain `interface{}` as maps, slices, and scalars (as the JSON and common YAML libraries do).
+func Structured(info string, tolerance float64) Comparator {
+	return func(actual, expect []byte) []Difference {
+		e, err := decodeAny(info, expect)
+		if err != nil {
+			return []Difference{{"$", fmt.Sprintf("expected content could not be parsed as %s: %s", info, err)}}
+		}
+		a, err := decodeAny(info, actual)
+		if err != nil {
+			return []Difference{{"$", fmt.Sprintf("actual content could not be parsed as %s: %s", info, err)}}
+		}
+		return compareValues(nil, "$", reflect.ValueOf(a), reflect.ValueOf(e), tolerance)
+	}
+}
+
+func decodeAny(info string, body []byte) (interface{}, error) {
+	var v interface{}
+	if info == "json" {
+		// We decode JSON ourselves, so we can keep numbers exact (the codec would make them float64).
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+		if _, err := dec.Token(); err != io.EOF {
+			return nil, fmt.Errorf("unexpected data after the first value")
+		}
+		return v, nil
+	}
+	codec, ok := testmark.LookupCodec(info)
+	if !ok {
+		return nil, fmt.Errorf("no codec registered for info string %q", info)
+	}
+	err := codec.Decode(body, &v, false)
+	return v, err
+}
+
+func compareValues(diffs []Difference, path string, a, e reflect.Value, tolerance float64) []Difference {
+	// Unwrap interfaces (and pointers), so we're looking at concrete things.
+	for a.IsValid() && (a.Kind() == reflect.Interface || a.Kind() == reflect.Ptr) {
+		a = a.Elem()
+	}
+	for e.IsValid() && (e.Kind() == reflect.Interface || e.Kind() == reflect.Ptr) {
+		e = e.Elem()
+	}
+
+	if !a.IsValid() || !e.IsValid() {
+		if a.IsValid() != e.IsValid() {
+			diffs = append(diffs, Difference{path, fmt.Sprintf("expected %s; actual %s", describe(e), describe(a))})
+		}
+		return diffs
+	}
+
+	if an, ok := asNumber(a); ok {
+		if en, ok := asNumber(e); ok {
+			if !numbersEqual(an, en, tolerance) {
+				msg := fmt.Sprintf("expected %s; actual %s", en, an)
+				if tolerance != 0 {
+					msg += fmt.Sprintf(" (tolerance %g)", tolerance)
+				}
+				diffs = append(diffs, Difference{path, msg})
+			}
+			return diffs
+		}
+	}
+
+	switch {
+	case e.Kind() == reflect.Map && a.Kind() == reflect.Map:
+		keys := map[string][2]reflect.Value{}
+		for _, k := range e.MapKeys() {
+			v := keys[fmt.Sprint(k.Interface())]
+			v[1] = e.MapIndex(k)
+			keys[fmt.Sprint(k.Interface())] = v
+		}
+		for _, k := range a.MapKeys() {
+			v := keys[fmt.Sprint(k.Interface())]
+			v[0] = a.MapIndex(k)
+			keys[fmt.Sprint(k.Interface())] = v
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			pair := keys[k]
+			subpath := path + mapKeyPath(k)
+			switch {
+			case !pair[0].IsValid():
+				diffs = append(diffs, Difference{subpath, "expected key is missing"})
+			case !pair[1].IsValid():
+				diffs = append(diffs, Difference{subpath, "unexpected key"})
+			default:
+				diffs = compareValues(diffs, subpath, pair[0], pair[1], tolerance)
+			}
+		}
+		return diffs
+	case isList(e) && isList(a):
+		n := e.Len()
+		if a.Len() < n {
+			n = a.Len()
+		}
+		for i := 0; i < n; i++ {
+			diffs = compareValues(diffs, fmt.Sprintf("%s[%d]", path, i), a.Index(i), e.Index(i), tolerance)
+		}
+		for i := n; i < e.Len(); i++ {
+			diffs = append(diffs, Difference{fmt.Sprintf("%s[%d]", path, i), fmt.Sprintf("expected %s; actual list ended", describe(e.Index(i)))})
+		}
+		for i := n; i < a.Len(); i++ {
+			diffs = append(diffs, Difference{fmt.Sprintf("%s[%d]", path, i), fmt.Sprintf("unexpected %s", describe(a.Index(i)))})
+		}
+		return diffs
+	case e.Kind() == reflect.String && a.Kind() == reflect.String:
+		if e.String() != a.String() {
+			diffs = append(diffs, Difference{path, fmt.Sprintf("expected %q; actual %q", e.String(), a.String())})
+		}
+		return diffs
+	case e.Kind() == reflect.Bool && a.Kind() == reflect.Bool:
+		if e.Bool() != a.Bool() {
+			diffs = append(diffs, Difference{path, fmt.Sprintf("expected %t; actual %t", e.Bool(), a.Bool())})
+		}
+		return diffs
+	}
+	if !reflect.DeepEqual(a.Interface(), e.Interface()) {
+		diffs = append(diffs, Difference{path, fmt.Sprintf("expected %s; actual %s", describe(e), describe(a))})
+	}
+	return diffs
+}
+
+func isList(v reflect.Value) bool {
+	return (v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8) || v.Kind() == reflect.Array
+}
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func mapKeyPath(k string) string {
+	if identifierPattern.MatchString(k) {
+		return "." + k
+	}
+	return "[" + strconv.Quote(k) + "]"
+}
+
+// describe renders a value briefly, for messages.
+func describe(v reflect.Value) string {
+	for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return "null"
+	}
+	switch {
+	case v.Kind() == reflect.Map:
+		return fmt.Sprintf("a map with %d entries", v.Len())
+	case isList(v):
+		return fmt.Sprintf("a list with %d entries", v.Len())
+	case v.Kind() == reflect.String:
+		return strconv.Quote(v.String())
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
+
+// number holds a numeric value both as text (for exact comparison and messages) and as a float (for tolerances).
+type number struct {
+	text  string
+	float float64
+}
+
+func (n number) String() string { return n.text }
+
+func asNumber(v reflect.Value) (number, bool) {
+	if v.Type() == reflect.TypeOf(json.Number("")) {
+		f, err := strconv.ParseFloat(v.String(), 64)
+		return number{v.String(), f}, err == nil
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return number{strconv.FormatInt(v.Int(), 10), float64(v.Int())}, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return number{strconv.FormatUint(v.Uint(), 10), float64(v.Uint())}, true
+	case reflect.Float32, reflect.Float64:
+		return number{strconv.FormatFloat(v.Float(), 'g', -1, 64), v.Float()}, true
+	}
+	return number{}, false
+}
+
+func numbersEqual(a, e number, tolerance float64) bool {
+	if a.text == e.text {
+		return true
+	}
+	if tolerance == 0 {
+		// Still equal if it's just a difference in notation, like "1.0" vs "1" (or "1e3" vs "1000").
+		// Don't trust floats for this if they're beyond exact integer range, though.
+		if math.Abs(e.float) < 1<<53 {
+			return a.float == e.float
+		}
+		return false
+	}
+	return withinTolerance(a.float, e.float, tolerance)
+}
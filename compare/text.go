@@ -0,0 +1,98 @@
+package compare
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Exact returns a comparator that requires the content to be byte-for-byte identical.
+// The first differing line is reported.
+func Exact() Comparator {
+	return func(actual, expect []byte) []Difference {
+		if string(actual) == string(expect) {
+			return nil
+		}
+		actualLines, expectLines := strings.Split(string(actual), "\n"), strings.Split(string(expect), "\n")
+		for i := 0; i < len(actualLines) && i < len(expectLines); i++ {
+			if actualLines[i] != expectLines[i] {
+				return []Difference{{fmt.Sprintf("line %d", i+1), fmt.Sprintf("expected %q; actual %q", expectLines[i], actualLines[i])}}
+			}
+		}
+		if len(actualLines) < len(expectLines) {
+			return []Difference{{fmt.Sprintf("line %d", len(actualLines)+1), fmt.Sprintf("expected %q; actual content ended", expectLines[len(actualLines)])}}
+		}
+		return []Difference{{fmt.Sprintf("line %d", len(expectLines)+1), fmt.Sprintf("expected content to end; actual %q", actualLines[len(expectLines)])}}
+	}
+}
+
+// LineSet returns a comparator that requires the same lines to be present, but in any order.
+// Repeated lines have to be repeated the same number of times.
+// Each expected line that's missing, and each actual line that wasn't expected, is reported.
+func LineSet() Comparator {
+	return func(actual, expect []byte) []Difference {
+		want := map[string]int{}
+		for _, line := range nonEmptyLines(expect) {
+			want[line]++
+		}
+		var diffs []Difference
+		for i, line := range nonEmptyLines(actual) {
+			if want[line] > 0 {
+				want[line]--
+				continue
+			}
+			diffs = append(diffs, Difference{fmt.Sprintf("actual line %d", i+1), fmt.Sprintf("unexpected line %q", line)})
+		}
+		for i, line := range nonEmptyLines(expect) {
+			if want[line] > 0 {
+				want[line]--
+				diffs = append(diffs, Difference{fmt.Sprintf("line %d", i+1), fmt.Sprintf("expected line %q is missing", line)})
+			}
+		}
+		return diffs
+	}
+}
+
+// Numbers returns a comparator for content that's a series of numbers (separated by any whitespace).
+// Each number must be within the tolerance of the expected number.
+func Numbers(tolerance float64) Comparator {
+	return func(actual, expect []byte) []Difference {
+		actualFields, expectFields := strings.Fields(string(actual)), strings.Fields(string(expect))
+		if len(actualFields) != len(expectFields) {
+			return []Difference{{"$", fmt.Sprintf("expected %d numbers; actual %d", len(expectFields), len(actualFields))}}
+		}
+		var diffs []Difference
+		for i := range expectFields {
+			path := fmt.Sprintf("[%d]", i)
+			e, err := strconv.ParseFloat(expectFields[i], 64)
+			if err != nil {
+				diffs = append(diffs, Difference{path, fmt.Sprintf("expected value %q is not a number", expectFields[i])})
+				continue
+			}
+			a, err := strconv.ParseFloat(actualFields[i], 64)
+			if err != nil {
+				diffs = append(diffs, Difference{path, fmt.Sprintf("actual value %q is not a number", actualFields[i])})
+				continue
+			}
+			if !withinTolerance(a, e, tolerance) {
+				diffs = append(diffs, Difference{path, fmt.Sprintf("expected %s; actual %s (tolerance %g)", expectFields[i], actualFields[i], tolerance)})
+			}
+		}
+		return diffs
+	}
+}
+
+func withinTolerance(a, e, tolerance float64) bool {
+	return a == e || math.Abs(a-e) <= tolerance
+}
+
+func nonEmptyLines(body []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(body), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,128 @@
+package testmark
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// DocumentDiff describes the differences between two Documents, hunk by hunk.
+// The prose between hunks isn't considered at all.
+//
+// Each list is in the order the hunks appear in their document
+// (the "b" document for Added, Renamed, and Modified; the "a" document for Removed).
+type DocumentDiff struct {
+	Added    []DocHunk    // Hunks only present in the "b" document (and not just a rename).
+	Removed  []DocHunk    // Hunks only present in the "a" document (and not just a rename).
+	Renamed  []HunkRename // Hunks which have the same body (and info string) but a different name.
+	Modified []HunkChange // Hunks which have the same name, but a different body or info string.
+}
+
+// HunkRename describes a hunk which went from one name to another without its content changing.
+type HunkRename struct {
+	From DocHunk // As it was in the "a" document.
+	To   DocHunk // As it is in the "b" document.
+}
+
+// HunkChange describes a hunk which kept its name, but had its content changed.
+type HunkChange struct {
+	From DocHunk // As it was in the "a" document.
+	To   DocHunk // As it is in the "b" document.
+
+	// A unified diff of the body lines.
+	// The line numbers in it are those of the respective documents (one-indexed, like any other diff).
+	// Empty if only the InfoString changed.
+	BodyDiff string
+}
+
+// Diff compares two documents and reports which hunks were added, removed, renamed, or modified.
+//
+// Hunks are matched up by name first.
+// Of the hunks that don't match by name, any with identical bodies (and info strings)
+// are considered renames.  (If there are several candidates, they're paired up in document order.)
+func Diff(a, b *Document) DocumentDiff {
+	var diff DocumentDiff
+	var removed, added []DocHunk
+	for _, hunk := range a.DataHunks {
+		if _, exists := b.HunksByName[hunk.Name]; !exists {
+			removed = append(removed, hunk)
+		}
+	}
+	for _, hunk := range b.DataHunks {
+		old, exists := a.HunksByName[hunk.Name]
+		if !exists {
+			added = append(added, hunk)
+			continue
+		}
+		if old.InfoString == hunk.InfoString && bytes.Equal(old.Body, hunk.Body) {
+			continue
+		}
+		diff.Modified = append(diff.Modified, HunkChange{
+			From:     old,
+			To:       hunk,
+			BodyDiff: diffHunkBodies(old, hunk),
+		})
+	}
+
+	// Pair up renames.
+	claimed := make([]bool, len(removed))
+	for _, hunk := range added {
+		renamed := false
+		for i, old := range removed {
+			if claimed[i] || old.InfoString != hunk.InfoString || !bytes.Equal(old.Body, hunk.Body) {
+				continue
+			}
+			claimed[i] = true
+			renamed = true
+			diff.Renamed = append(diff.Renamed, HunkRename{From: old, To: hunk})
+			break
+		}
+		if !renamed {
+			diff.Added = append(diff.Added, hunk)
+		}
+	}
+	for i, old := range removed {
+		if !claimed[i] {
+			diff.Removed = append(diff.Removed, old)
+		}
+	}
+	return diff
+}
+
+// diffHunkBodies produces a unified diff of two hunks' bodies, using line numbers from their documents.
+func diffHunkBodies(a, b DocHunk) string {
+	return UnifiedDiff(a.Body, b.Body, DiffConfig{
+		ExpectLabel:      "a/" + a.Name,
+		ActualLabel:      "b/" + b.Name,
+		ExpectLineOffset: a.LineStart + 2,
+		ActualLineOffset: b.LineStart + 2,
+	})
+}
+
+// IsEmpty returns true if the two documents had no differences in their hunks.
+func (d DocumentDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Renamed) == 0 && len(d.Modified) == 0
+}
+
+// String renders a summary of the changes, including the body diffs of any modified hunks.
+// Line numbers are one-indexed, for humans.
+func (d DocumentDiff) String() string {
+	var sb strings.Builder
+	for _, hunk := range d.Removed {
+		fmt.Fprintf(&sb, "removed hunk %q (was at line %d)\n", hunk.Name, hunk.LineStart+1)
+	}
+	for _, hunk := range d.Added {
+		fmt.Fprintf(&sb, "added hunk %q (at line %d)\n", hunk.Name, hunk.LineStart+1)
+	}
+	for _, rename := range d.Renamed {
+		fmt.Fprintf(&sb, "renamed hunk %q (was at line %d) to %q (at line %d)\n", rename.From.Name, rename.From.LineStart+1, rename.To.Name, rename.To.LineStart+1)
+	}
+	for _, change := range d.Modified {
+		fmt.Fprintf(&sb, "modified hunk %q (was at line %d, now at line %d)\n", change.From.Name, change.From.LineStart+1, change.To.LineStart+1)
+		if change.From.InfoString != change.To.InfoString {
+			fmt.Fprintf(&sb, "info string changed from %q to %q\n", change.From.InfoString, change.To.InfoString)
+		}
+		sb.WriteString(change.BodyDiff)
+	}
+	return sb.String()
+}
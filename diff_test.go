@@ -0,0 +1,98 @@
+package testmark_test
+
+import (
+	"testing"
+
+	"github.com/warpfork/go-testmark"
+)
+
+func TestDiff(t *testing.T) {
+	a, err := testmark.Parse([]byte(`# before
+
+[testmark]:# (unchanged)
+` + "```" + `
+same
+` + "```" + `
+
+[testmark]:# (going-away)
+` + "```" + `
+bye
+` + "```" + `
+
+[testmark]:# (old-name)
+` + "```json" + `
+{"moved": true}
+` + "```" + `
+
+[testmark]:# (changing)
+` + "```" + `
+one
+two
+three
+four
+five
+six
+seven
+eight
+` + "```" + `
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := testmark.Parse([]byte(`# after
+
+Some new prose, which doesn't matter.
+
+[testmark]:# (unchanged)
+` + "```" + `
+same
+` + "```" + `
+
+[testmark]:# (changing)
+` + "```" + `
+one
+two
+three
+four
+5
+six
+seven
+eight
+` + "```" + `
+
+[testmark]:# (new-name)
+` + "```json" + `
+{"moved": true}
+` + "```" + `
+
+[testmark]:# (brand-new)
+` + "```" + `
+hello
+` + "```" + `
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := testmark.Diff(a, b)
+	assert(t, diff.String(), `removed hunk "going-away" (was at line 8)
+added hunk "brand-new" (at line 27)
+renamed hunk "old-name" (was at line 13) to "new-name" (at line 22)
+modified hunk "changing" (was at line 18, now at line 10)
+--- a/changing
++++ b/changing
+@@ -21,7 +13,7 @@
+ two
+ three
+ four
+-five
++5
+ six
+ seven
+ eight
+`)
+
+	if !testmark.Diff(a, a).IsEmpty() {
+		t.Errorf("a document should have no differences from itself")
+	}
+}
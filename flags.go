@@ -5,3 +5,7 @@ import (
 )
 
 var Regen = flag.Bool("testmark.regen", false, "Setting this flag hints to systems using testmark tests that fixtures should be regenerated.")
+
+var RegenPrune = flag.Bool("testmark.prune", false, "Setting this flag (together with testmark.regen) hints to systems using testmark tests that hunks no test used should be removed from fixtures.")
+
+var PruneDryRun = flag.Bool("testmark.prune.dryrun", false, "Setting this flag (together with testmark.regen) hints to systems using testmark tests that they should list the hunks testmark.prune would remove, without removing them. (Regeneration still happens as usual, so fixtures may still be rewritten.)")
@@ -18,13 +18,14 @@ const HunkPathSeparator = "/"
 func (doc *Document) BuildDirIndex() {
 	doc.DirEnt = &DirEnt{}
 	for _, hunk := range doc.DataHunks {
-		doc.DirEnt.fill(strings.Split(hunk.Name, HunkPathSeparator), 0, hunk.Hunk)
+		doc.DirEnt.fill(strings.Split(hunk.Name, HunkPathSeparator), 0, hunk)
 	}
 }
 
-func (dirent *DirEnt) fill(pathSegs []string, pathIdx int, hunk Hunk) {
+func (dirent *DirEnt) fill(pathSegs []string, pathIdx int, hunk DocHunk) {
 	if pathIdx >= len(pathSegs) {
-		dirent.Hunk = &hunk
+		dirent.DocHunk = &hunk
+		dirent.Hunk = &hunk.Hunk
 		return
 	}
 	if dirent.Children == nil {
@@ -0,0 +1,284 @@
+package testmark
+
+import (
+	"fmt"
+	"strings"
+)
+
+// This file contains a small line-based diff implementation (Myers' algorithm),
+// so that we can describe changes to hunk bodies (and failed comparisons) without needing any dependencies.
+
+// DiffConfig holds options for UnifiedDiff.  The zero value is ready to use.
+type DiffConfig struct {
+	// How many unchanged lines to show around each change.
+	// If zero, the default of 3 is used; use a negative number to show none.
+	Context int
+
+	// Names for each side, used in the "---" and "+++" header lines.
+	// Default to "expected" and "actual".
+	ExpectLabel string
+	ActualLabel string
+
+	// Added to the line numbers in the "@@" headers for each side.
+	// This lets the line numbers refer to positions in a whole document, rather than just within a hunk body.
+	ExpectLineOffset int
+	ActualLineOffset int
+}
+
+// HunkDiffConfig returns a DiffConfig that labels the expected side with the location of a hunk,
+// like "fixtures.md:12 (hunk "foo/stdout")", and numbers its lines to match the document the hunk is in.
+// The filename may be empty, if it's not known.
+func HunkDiffConfig(filename string, hunk DocHunk) DiffConfig {
+	return DiffConfig{
+		ExpectLabel:      fmt.Sprintf("%s:%d (hunk %q)", filename, hunk.LineStart+1, hunk.Name),
+		ExpectLineOffset: hunk.LineStart + 2,
+	}
+}
+
+// UnifiedDiff produces a line-based diff of expected versus actual content, in unified diff format,
+// with the expected content as the "-" side, and the actual content as the "+" side.
+// It returns an empty string if the two are equal.
+//
+// Changes that are otherwise invisible are marked:
+// trailing whitespace on changed lines is shown with visible characters ('·' for a space, '→' for a tab, '␍' for a carriage return),
+// and a missing linebreak at the end of either side is noted with a "\ No newline at end of file" line, as in other diff tools.
+func UnifiedDiff(expect, actual []byte, cfg DiffConfig) string {
+	if string(expect) == string(actual) {
+		return ""
+	}
+	if cfg.Context == 0 {
+		cfg.Context = 3
+	} else if cfg.Context < 0 {
+		cfg.Context = 0
+	}
+	if cfg.ExpectLabel == "" {
+		cfg.ExpectLabel = "expected"
+	}
+	if cfg.ActualLabel == "" {
+		cfg.ActualLabel = "actual"
+	}
+	a, b := diffableLines(expect), diffableLines(actual)
+	body := formatUnified(diffLines(a, b), a, b, cfg)
+	return fmt.Sprintf("--- %s\n+++ %s\n%s", cfg.ExpectLabel, cfg.ActualLabel, body)
+}
+
+// sigilNoNewline is appended to the last line, if it has no linebreak after it.
+// This makes the line compare unequal to the same text with a linebreak, as it should.
+const sigilNoNewline = "\x00no-newline"
+
+type diffOpKind byte
+
+const (
+	diffEqual  diffOpKind = ' '
+	diffDelete diffOpKind = '-'
+	diffInsert diffOpKind = '+'
+)
+
+// diffOp is one step of an edit script.
+// The a and b fields are the indexes in each side that the op is at
+// (for inserts, a is where in the old side the insert lands; for deletes, likewise b).
+type diffOp struct {
+	kind diffOpKind
+	a, b int
+}
+
+// diffLines computes a minimal edit script turning a into b.
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	// Remember the part of v that each step started from, so the path can be recovered afterwards.
+	// Step d only reads diagonals -d-1 through d+1, so that's all that's kept (trace[d][0] is diagonal -d-1),
+	// which makes this grow with the square of the number of differences, rather than with their number times the size of the input.
+	// If that's still too much, settle for a diff that's not minimal.
+	var trace [][]int
+	kept := 0
+search:
+	for d := 0; d <= max; d++ {
+		if kept += 2*d + 3; kept > maxDiffTrace {
+			return diffLinesCoarse(a, b)
+		}
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // down: an insert.
+			} else {
+				x = v[offset+k-1] + 1 // right: a delete.
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk back through the trace to recover the path, then flip it around.
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v, offset := trace[d], d+1
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			ops = append(ops, diffOp{diffEqual, x, y})
+		}
+		if d > 0 {
+			if x == prevX {
+				y--
+				ops = append(ops, diffOp{diffInsert, x, y})
+			} else {
+				x--
+				ops = append(ops, diffOp{diffDelete, x, y})
+			}
+		}
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// maxDiffTrace limits how much diffLines remembers (in ints) while it searches for a minimal diff.
+var maxDiffTrace = 1 << 22
+
+// diffLinesCoarse computes an edit script turning a into b that keeps the lines the two have in common at the start and end,
+// and replaces everything in between.
+func diffLinesCoarse(a, b []string) []diffOp {
+	var ops []diffOp
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		ops = append(ops, diffOp{diffEqual, start, start})
+		start++
+	}
+	end := 0
+	for end < len(a)-start && end < len(b)-start && a[len(a)-1-end] == b[len(b)-1-end] {
+		end++
+	}
+	for x := start; x < len(a)-end; x++ {
+		ops = append(ops, diffOp{diffDelete, x, start})
+	}
+	for y := start; y < len(b)-end; y++ {
+		ops = append(ops, diffOp{diffInsert, len(a) - end, y})
+	}
+	for i := end; i > 0; i-- {
+		ops = append(ops, diffOp{diffEqual, len(a) - i, len(b) - i})
+	}
+	return ops
+}
+
+// diffableLines splits content into lines, without their linebreaks.
+// A trailing linebreak doesn't produce an extra empty line;
+// and if there's no trailing linebreak, the last line is marked with sigilNoNewline.
+func diffableLines(body []byte) []string {
+	if len(body) == 0 {
+		return nil
+	}
+	lines := strings.Split(string(body), "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += sigilNoNewline
+	return lines
+}
+
+// formatUnified renders an edit script in unified diff style (just the "@@" chunks; not the "---" and "+++" headers).
+func formatUnified(ops []diffOp, a, b []string, cfg DiffConfig) string {
+	var sb strings.Builder
+	for i := 0; i < len(ops); {
+		// Find the next change.
+		if ops[i].kind == diffEqual {
+			i++
+			continue
+		}
+		// Extend the chunk for as long as changes are within 2*context of each other.
+		start := i - cfg.Context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != diffEqual {
+				end = j
+			} else if j-end > 2*cfg.Context {
+				break
+			}
+		}
+		end += cfg.Context + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		// Header, then the lines.
+		var aCount, bCount int
+		for _, op := range ops[start:end] {
+			if op.kind != diffInsert {
+				aCount++
+			}
+			if op.kind != diffDelete {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n",
+			unifiedRange(ops[start].a+cfg.ExpectLineOffset, aCount),
+			unifiedRange(ops[start].b+cfg.ActualLineOffset, bCount))
+		for _, op := range ops[start:end] {
+			switch op.kind {
+			case diffEqual:
+				writeDiffLine(&sb, ' ', a[op.a], false)
+			case diffDelete:
+				writeDiffLine(&sb, '-', a[op.a], true)
+			case diffInsert:
+				writeDiffLine(&sb, '+', b[op.b], true)
+			}
+		}
+		i = end
+	}
+	return sb.String()
+}
+
+func writeDiffLine(sb *strings.Builder, prefix byte, line string, changed bool) {
+	noNewline := strings.HasSuffix(line, sigilNoNewline)
+	line = strings.TrimSuffix(line, sigilNoNewline)
+	if changed {
+		line = markTrailingWhitespace(line)
+	}
+	sb.WriteByte(prefix)
+	sb.WriteString(line)
+	sb.WriteByte('\n')
+	if noNewline {
+		sb.WriteString("\\ No newline at end of file\n")
+	}
+}
+
+var whitespaceMarkers = strings.NewReplacer(" ", "·", "\t", "→", "\r", "␍")
+
+func markTrailingWhitespace(line string) string {
+	trimmed := strings.TrimRight(line, " \t\r")
+	return trimmed + whitespaceMarkers.Replace(line[len(trimmed):])
+}
+
+// unifiedRange formats the "start,count" part of a unified diff header.
+// The start is given zero-indexed; like in GNU diff, an empty range names the line before it.
+func unifiedRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
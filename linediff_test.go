@@ -0,0 +1,101 @@
+package testmark
+
+import (
+	"strings"
+	"testing"
+)
+
+var diffLinesCases = []struct{ a, b string }{
+	{"", ""},
+	{"", "a\nb"},
+	{"a\nb", ""},
+	{"a\nb\nc", "a\nb\nc"},
+	{"a\nb\nc", "a\nc"},
+	{"a\nb\nc\na\nb\nb\na", "c\nb\na\nb\na\nc"},
+	{"a\nb\nc\nd", "a\nx\ny\nd"},
+}
+
+func TestDiffLines(t *testing.T) {
+	for _, tc := range diffLinesCases {
+		checkDiffLines(t, tc.a, tc.b)
+	}
+}
+
+// TestDiffLinesCoarse checks the diffs made when a minimal one would take too much memory to find.
+func TestDiffLinesCoarse(t *testing.T) {
+	defer func(max int) { maxDiffTrace = max }(maxDiffTrace)
+	maxDiffTrace = 0
+	for _, tc := range diffLinesCases {
+		checkDiffLines(t, tc.a, tc.b)
+	}
+	// Big inputs with nothing in common fall back to the coarse diff with the default limit, too.
+	maxDiffTrace = 1 << 22
+	a := strings.Repeat("x\n", 3000) + "end"
+	b := strings.Repeat("y\n", 3000) + "end"
+	ops := checkDiffLines(t, a, b)
+	if len(ops) != 6001 || ops[6000].kind != diffEqual {
+		t.Errorf("expected 3000 deletes, 3000 inserts, and the last line kept; got %d ops", len(ops))
+	}
+}
+
+// checkDiffLines checks that replaying the edit script turns a into b, and that the equal lines are actually equal.
+func checkDiffLines(t *testing.T, textA, textB string) []diffOp {
+	t.Helper()
+	a, b := strings.Split(textA, "\n"), strings.Split(textB, "\n")
+	if textA == "" {
+		a = nil
+	}
+	if textB == "" {
+		b = nil
+	}
+	var result []string
+	ops := diffLines(a, b)
+	for _, op := range ops {
+		switch op.kind {
+		case diffEqual:
+			if a[op.a] != b[op.b] {
+				t.Errorf("%q -> %q: lines marked equal differ: %q != %q", textA, textB, a[op.a], b[op.b])
+			}
+			result = append(result, a[op.a])
+		case diffInsert:
+			result = append(result, b[op.b])
+		}
+	}
+	if strings.Join(result, "\n") != textB {
+		t.Errorf("%q -> %q: replaying the diff produced %q", textA, textB, strings.Join(result, "\n"))
+	}
+	return ops
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	expect := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+	actual := "one\ntwo\nthree\nfour\nfive \nsix\nseven\neight\nnine\nten"
+	diff := UnifiedDiff([]byte(expect), []byte(actual), HunkDiffConfig("fixture.md", DocHunk{LineStart: 10, Hunk: Hunk{Name: "foo/stdout"}}))
+	if diff != `--- fixture.md:11 (hunk "foo/stdout")
++++ actual
+@@ -14,9 +2,9 @@
+ two
+ three
+ four
+-five
++five·
+ six
+ seven
+ eight
+ nine
+-ten
++ten
+\ No newline at end of file
+` {
+		t.Errorf("unexpected diff:\n%s", diff)
+	}
+
+	if diff := UnifiedDiff([]byte("same\n"), []byte("same\n"), DiffConfig{}); diff != "" {
+		t.Errorf("equal content should produce no diff, got:\n%s", diff)
+	}
+
+	diff = UnifiedDiff([]byte("a\nb\n"), []byte("a\n\tc\n"), DiffConfig{Context: -1})
+	if diff != "--- expected\n+++ actual\n@@ -2 +2 @@\n-b\n+\tc\n" {
+		t.Errorf("unexpected diff:\n%s", diff)
+	}
+}
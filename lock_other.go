@@ -0,0 +1,14 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package testmark
+
+import (
+	"os"
+)
+
+// lockFile is a no-op on platforms where we don't have flock.
+// UpdateFile still re-reads and writes atomically; it just can't keep other writers out.
+func lockFile(f *os.File) error {
+	return nil
+}
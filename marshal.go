@@ -0,0 +1,249 @@
+package testmark
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Unmarshal fills in a struct from the hunks beneath a DirEnt.
+// This saves a lot of `Children["input"].Hunk.Body` when writing table-driven tests over hunk trees.
+//
+// The argument must be a pointer to a struct.
+// Each exported field is matched to a child of the DirEnt by name.
+// By default the name is the field name, but it can be set with a struct tag, like `testmark:"input"`.
+// A tag of `testmark:"-"` means the field is skipped.
+//
+// Fields can be:
+//
+//   - []byte or string, which receive the hunk body;
+//   - any int or uint kind, which receive the hunk body parsed as a base-10 number (surrounding whitespace is ignored);
+//   - a struct, which is filled in from the child as a directory (recursively, with the same rules);
+//   - a map with string keys, which gets one entry for each child of the directory (the values can be any of these kinds);
+//   - a pointer to any of these, which is allocated if the hunk is present (and left nil otherwise).
+//
+// Every field is required, unless the tag says it's optional: `testmark:"expect,optional"`.
+// Any child of the DirEnt that doesn't match a field is also an error.
+// Errors name the full path of the missing or unexpected hunk.
+func Unmarshal(dir *DirEnt, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("testmark.Unmarshal needs a non-nil pointer to a struct, not %T", v)
+	}
+	if dir == nil {
+		return fmt.Errorf("testmark.Unmarshal needs a DirEnt, not nil (is the document's DirEnt built, and does the path exist?)")
+	}
+	return unmarshalStruct(dir, rv.Elem())
+}
+
+// Marshal produces hunks from a struct, using the same rules as Unmarshal.
+// The hunk names are the field names appended to the prefix (with a slash, unless the prefix is empty).
+// The results are ready to hand to Patch.
+//
+// Optional fields are skipped if they have their zero value.
+// Since Patch replaces the info string of any hunk it patches, the tag can set it, like `testmark:"output,info=json"`.
+// Numbers are written with a trailing linebreak, as Parse would see them.
+// Map entries are produced in sorted order.
+func Marshal(prefix string, v interface{}) ([]Hunk, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("testmark.Marshal needs a struct (or pointer to one), not %T", v)
+	}
+	return marshalStruct(nil, prefix, rv)
+}
+
+// fieldInfo is what we figured out from a struct field and its tag.
+type fieldInfo struct {
+	index    int
+	name     string
+	optional bool
+	info     string
+}
+
+func structFields(rt reflect.Type) []fieldInfo {
+	var fields []fieldInfo
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if sf.PkgPath != "" { // unexported
+			continue
+		}
+		fi := fieldInfo{index: i, name: sf.Name}
+		if tag, ok := sf.Tag.Lookup("testmark"); ok {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				fi.name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				switch {
+				case opt == "optional":
+					fi.optional = true
+				case strings.HasPrefix(opt, "info="):
+					fi.info = opt[len("info="):]
+				}
+			}
+		}
+		fields = append(fields, fi)
+	}
+	return fields
+}
+
+func unmarshalStruct(dir *DirEnt, rv reflect.Value) error {
+	seen := make(map[string]struct{})
+	for _, fi := range structFields(rv.Type()) {
+		seen[fi.name] = struct{}{}
+		child := dir.Children[fi.name]
+		if child == nil {
+			if !fi.optional {
+				return fmt.Errorf("missing hunk %q (for field %s)", joinHunkPath(dir.Path, fi.name), rv.Type().Field(fi.index).Name)
+			}
+			continue
+		}
+		if err := unmarshalValue(child, rv.Field(fi.index)); err != nil {
+			return err
+		}
+	}
+	for _, child := range dir.ChildrenList {
+		if _, ok := seen[child.Name]; !ok {
+			return fmt.Errorf("unexpected hunk %q (no field in %s matches it)", child.Path, rv.Type())
+		}
+	}
+	return nil
+}
+
+func unmarshalValue(ent *DirEnt, rv reflect.Value) error {
+	switch rv.Kind() {
+	case reflect.Ptr:
+		nv := reflect.New(rv.Type().Elem())
+		if err := unmarshalValue(ent, nv.Elem()); err != nil {
+			return err
+		}
+		rv.Set(nv)
+		return nil
+	case reflect.Struct:
+		return unmarshalStruct(ent, rv)
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("cannot unmarshal hunks at %q into %s: map keys must be strings", ent.Path, rv.Type())
+		}
+		m := reflect.MakeMap(rv.Type())
+		for _, child := range ent.ChildrenList {
+			ev := reflect.New(rv.Type().Elem()).Elem()
+			if err := unmarshalValue(child, ev); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(child.Name).Convert(rv.Type().Key()), ev)
+		}
+		rv.Set(m)
+		return nil
+	}
+
+	// Everything else wants a hunk body, and not a directory.
+	if ent.Hunk == nil {
+		return fmt.Errorf("missing hunk %q (there are only hunks beneath it)", ent.Path)
+	}
+	if len(ent.Children) > 0 {
+		return fmt.Errorf("unexpected hunk %q (%s can't hold hunks beneath it)", ent.ChildrenList[0].Path, rv.Type())
+	}
+	body := ent.Hunk.Body
+	switch rv.Kind() {
+	case reflect.String:
+		rv.SetString(string(body))
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("cannot unmarshal hunk %q into %s", ent.Path, rv.Type())
+		}
+		rv.SetBytes(append([]byte(nil), body...))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(string(body)), 10, rv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal hunk %q into %s: %w", ent.Path, rv.Type(), err)
+		}
+		rv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(strings.TrimSpace(string(body)), 10, rv.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal hunk %q into %s: %w", ent.Path, rv.Type(), err)
+		}
+		rv.SetUint(n)
+	default:
+		return fmt.Errorf("cannot unmarshal hunk %q into %s", ent.Path, rv.Type())
+	}
+	return nil
+}
+
+func marshalStruct(hunks []Hunk, prefix string, rv reflect.Value) ([]Hunk, error) {
+	for _, fi := range structFields(rv.Type()) {
+		fv := rv.Field(fi.index)
+		if fi.optional && fv.IsZero() {
+			continue
+		}
+		var err error
+		hunks, err = marshalValue(hunks, joinHunkPath(prefix, fi.name), fi.info, fv)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hunks, nil
+}
+
+func marshalValue(hunks []Hunk, name string, info string, rv reflect.Value) ([]Hunk, error) {
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return hunks, nil
+		}
+		return marshalValue(hunks, name, info, rv.Elem())
+	case reflect.Struct:
+		return marshalStruct(hunks, name, rv)
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("cannot marshal %s into hunks at %q: map keys must be strings", rv.Type(), name)
+		}
+		keys := make([]string, 0, rv.Len())
+		for _, k := range rv.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			var err error
+			hunks, err = marshalValue(hunks, joinHunkPath(name, k), info, rv.MapIndex(reflect.ValueOf(k).Convert(rv.Type().Key())))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return hunks, nil
+	}
+
+	var body []byte
+	switch rv.Kind() {
+	case reflect.String:
+		body = []byte(rv.String())
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() != reflect.Uint8 {
+			return nil, fmt.Errorf("cannot marshal %s into hunk %q", rv.Type(), name)
+		}
+		body = append([]byte(nil), rv.Bytes()...)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		body = []byte(strconv.FormatInt(rv.Int(), 10) + "\n")
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		body = []byte(strconv.FormatUint(rv.Uint(), 10) + "\n")
+	default:
+		return nil, fmt.Errorf("cannot marshal %s into hunk %q", rv.Type(), name)
+	}
+	return append(hunks, Hunk{Name: name, InfoString: info, Body: body}), nil
+}
+
+func joinHunkPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + HunkPathSeparator + name
+}
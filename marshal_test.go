@@ -0,0 +1,92 @@
+package testmark_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/warpfork/go-testmark"
+)
+
+type marshalCase struct {
+	Input    []byte            `testmark:"input,info=json"`
+	Expect   string            `testmark:"expect,optional"`
+	Exitcode int               `testmark:"exitcode"`
+	Fs       map[string]string `testmark:"fs,optional"`
+	Then     *marshalCase      `testmark:"then,optional"`
+	Ignored  string            `testmark:"-"`
+}
+
+func TestUnmarshal(t *testing.T) {
+	doc, err := testmark.Parse([]byte("" +
+		"[testmark]:# (case/input)\n```json\n{}\n```\n" +
+		"[testmark]:# (case/exitcode)\n```\n 3\n```\n" +
+		"[testmark]:# (case/fs/a.txt)\n```\naaa\n```\n" +
+		"[testmark]:# (case/fs/b.txt)\n```\nbbb\n```\n" +
+		"[testmark]:# (case/then/input)\n```\nmore\n```\n" +
+		"[testmark]:# (case/then/expect)\n```\nyes\n```\n" +
+		"[testmark]:# (case/then/exitcode)\n```\n0\n```\n" +
+		"[testmark]:# (bad/input)\n```\n\n```\n" +
+		"[testmark]:# (bad/exitcode)\n```\n0\n```\n" +
+		"[testmark]:# (bad/surprise)\n```\n\n```\n" +
+		"[testmark]:# (short/input)\n```\n\n```\n",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.BuildDirIndex()
+
+	var c marshalCase
+	if err := testmark.Unmarshal(doc.DirEnt.Children["case"], &c); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, c.Input, "{}\n")
+	assert(t, c.Expect, "")
+	assert(t, c.Exitcode, "3")
+	assert(t, fmt.Sprintf("%v", c.Fs), "map[a.txt:aaa\n b.txt:bbb\n]")
+	assert(t, c.Then.Input, "more\n")
+	assert(t, c.Then.Expect, "yes\n")
+	if c.Then.Then != nil {
+		t.Errorf("absent optional pointer should stay nil")
+	}
+
+	err = testmark.Unmarshal(doc.DirEnt.Children["bad"], &marshalCase{})
+	assert(t, err, `unexpected hunk "bad/surprise" (no field in testmark_test.marshalCase matches it)`)
+	err = testmark.Unmarshal(doc.DirEnt.Children["short"], &marshalCase{})
+	assert(t, err, `missing hunk "short/exitcode" (for field Exitcode)`)
+	err = testmark.Unmarshal(doc.DirEnt.Children["absent"], &marshalCase{})
+	assert(t, err, `testmark.Unmarshal needs a DirEnt, not nil (is the document's DirEnt built, and does the path exist?)`)
+}
+
+func TestMarshal(t *testing.T) {
+	hunks, err := testmark.Marshal("case", marshalCase{
+		Input:    []byte("{}\n"),
+		Exitcode: 0,
+		Fs:       map[string]string{"b": "bbb\n", "a": "aaa\n"},
+		Then:     &marshalCase{Input: []byte("more\n"), Expect: "yes\n", Exitcode: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, hunk := range hunks {
+		names = append(names, fmt.Sprintf("%s(%s)=%q", hunk.Name, hunk.InfoString, hunk.Body))
+	}
+	assert(t, fmt.Sprintf("%v", names), `[`+
+		`case/input(json)="{}\n" `+
+		`case/exitcode()="0\n" `+
+		`case/fs/a()="aaa\n" `+
+		`case/fs/b()="bbb\n" `+
+		`case/then/input(json)="more\n" `+
+		`case/then/expect()="yes\n" `+
+		`case/then/exitcode()="1\n"`+
+		`]`)
+
+	// And it should round-trip.
+	doc := testmark.Patch(&testmark.Document{}, hunks...)
+	doc.BuildDirIndex()
+	var c marshalCase
+	if err := testmark.Unmarshal(doc.DirEnt.Children["case"], &c); err != nil {
+		t.Fatal(err)
+	}
+	assert(t, c.Then.Exitcode, "1")
+}
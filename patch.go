@@ -2,6 +2,8 @@ package testmark
 
 import (
 	"bytes"
+	"fmt"
+	"sync"
 	"unicode"
 )
 
@@ -42,12 +44,9 @@ func Patch(oldDoc *Document, hunks ...Hunk) (newDoc *Document) {
 		if newHunk, exists := newHunks[hunk.Name]; exists {
 			// Split our new hunk's body into lines, ready to append to the total content lines.
 			// The rest... copy it into 'hunk', actually, it's a local variable and it makes the code slightly more DRY.
-			newBodyLines = bytes.Split(newHunk.Body, sigilLineBreak)
-			// If the last byte was a linebreak, the split will tend to exaggerate it a bit, so let's trim that back down.
-			if len(newHunk.Body) > 0 && newHunk.Body[len(newHunk.Body)-1] == '\n' {
-				newBodyLines = newBodyLines[0 : len(newBodyLines)-1]
-			}
+			newBodyLines = splitBodyLines(newHunk.Body)
 			hunk.InfoString = newHunk.InfoString
+			hunk.Body = newHunk.Body
 
 			// Yeet from newHunks, as it's now handled.
 			delete(newHunks, hunk.Name)
@@ -61,7 +60,7 @@ func Patch(oldDoc *Document, hunks ...Hunk) (newDoc *Document) {
 		// (If you're just going to serialize this, it wouldn't matter, but if you want to patch multiple times, it matters.)
 		newLineStart := len(newDoc.Lines)
 		newDoc.Lines = appendHunkLines(newDoc.Lines, hunk.Name, hunk.InfoString, newBodyLines)
-		newLineEnd := len(newDoc.Lines)
+		newLineEnd := len(newDoc.Lines) - 1 // Same as Parse: the index of the closing code block indicator.
 		docHunk := DocHunk{
 			LineStart: newLineStart,
 			LineEnd:   newLineEnd,
@@ -83,11 +82,19 @@ func Patch(oldDoc *Document, hunks ...Hunk) (newDoc *Document) {
 			continue
 		}
 		// If we're about to need to append something, make sure there's at least one blank line first.
-		if len(newDoc.Lines[len(newDoc.Lines)-1]) > 0 {
+		if l := len(newDoc.Lines); l > 0 && len(newDoc.Lines[l-1]) > 0 {
 			newDoc.Lines = append(newDoc.Lines, []byte{})
 		}
 		// Append it.
-		newDoc.Lines = appendHunkLines(newDoc.Lines, hunk.Name, hunk.InfoString, bytes.Split(hunk.Body, sigilLineBreak))
+		newLineStart := len(newDoc.Lines)
+		newDoc.Lines = appendHunkLines(newDoc.Lines, hunk.Name, hunk.InfoString, splitBodyLines(hunk.Body))
+		docHunk := DocHunk{
+			LineStart: newLineStart,
+			LineEnd:   len(newDoc.Lines) - 1,
+			Hunk:      hunk,
+		}
+		newDoc.DataHunks = append(newDoc.DataHunks, docHunk)
+		newDoc.HunksByName[hunk.Name] = docHunk
 		// And one more trailing line, at the end.
 		newDoc.Lines = append(newDoc.Lines, []byte{})
 	}
@@ -102,6 +109,19 @@ func Patch(oldDoc *Document, hunks ...Hunk) (newDoc *Document) {
 	return
 }
 
+// splitBodyLines splits a hunk body into the lines that go between the code block indicators.
+func splitBodyLines(body []byte) [][]byte {
+	if len(body) == 0 {
+		return nil
+	}
+	lines := bytes.Split(body, sigilLineBreak)
+	// If the last byte was a linebreak, the split will tend to exaggerate it a bit, so let's trim that back down.
+	if len(body) > 0 && body[len(body)-1] == '\n' {
+		lines = lines[0 : len(lines)-1]
+	}
+	return lines
+}
+
 func appendHunkLines(lines [][]byte, hunkName string, hunkBlockTag string, hunkBodyLines [][]byte) [][]byte {
 	lines = append(lines, bytes.Join([][]byte{sigilTestmark, {'('}, []byte(hunkName), {')'}}, nil))
 	lines = append(lines, bytes.Join([][]byte{sigilCodeBlock, []byte(hunkBlockTag)}, nil))
@@ -110,8 +130,21 @@ func appendHunkLines(lines [][]byte, hunkName string, hunkBlockTag string, hunkB
 	return lines
 }
 
+// PatchAccumulator gathers up patches (typically, during a test run in regen mode)
+// so that they can all be applied and written out at once at the end.
+//
+// A PatchAccumulator is safe to use from multiple goroutines at once
+// (e.g. from parallel subtests), as long as it's not copied after first use.
+// Reading the Patches field directly is only safe once all the writers are done.
+//
+// If the same hunk is patched more than once, and the bodies (or info strings) are identical,
+// the duplicate is quietly dropped.  If they differ, that's a conflict:
+// the last patch wins (as it always has), and the conflict is also reported by Err.
 type PatchAccumulator struct {
 	Patches []Hunk
+
+	mu        sync.Mutex
+	conflicts []string
 }
 
 func (pa *PatchAccumulator) AppendPatchIfBodyDiffers(hunk Hunk, newBody []byte) {
@@ -122,8 +155,39 @@ func (pa *PatchAccumulator) AppendPatchIfBodyDiffers(hunk Hunk, newBody []byte)
 }
 
 func (pa *PatchAccumulator) AppendPatch(hunk Hunk) {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
 	if pa.Patches == nil {
 		pa.Patches = make([]Hunk, 0)
 	}
+	for i, already := range pa.Patches {
+		if already.Name != hunk.Name {
+			continue
+		}
+		if already.InfoString != hunk.InfoString || !bytes.Equal(already.Body, hunk.Body) {
+			pa.conflicts = append(pa.conflicts, hunk.Name)
+			pa.Patches[i] = hunk
+		}
+		return
+	}
 	pa.Patches = append(pa.Patches, hunk)
 }
+
+// Err returns an error if any conflicting patches were appended
+// (that is, more than one patch for the same hunk name, with different content).
+// Returns nil if there were no conflicts.
+func (pa *PatchAccumulator) Err() error {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+	if len(pa.conflicts) == 0 {
+		return nil
+	}
+	return fmt.Errorf("conflicting patches with different content were produced for hunks %q (the last patch for each was kept)", pa.conflicts)
+}
+
+// snapshot returns a copy of the patches accumulated so far.
+func (pa *PatchAccumulator) snapshot() []Hunk {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+	return append([]Hunk(nil), pa.Patches...)
+}
@@ -1,7 +1,9 @@
 package testmark
 
 import (
+	"fmt"
 	"path/filepath"
+	"sync"
 	"testing"
 )
 
@@ -22,3 +24,113 @@ func TestPatch(t *testing.T) {
 	)
 	t.Logf("%s", doc.String())
 }
+
+func TestPatchAccumulator(t *testing.T) {
+	pa := PatchAccumulator{}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pa.AppendPatch(Hunk{Name: fmt.Sprintf("hunk-%d", i%10), Body: []byte(fmt.Sprintf("body %d\n", i%10))})
+		}()
+	}
+	wg.Wait()
+	if len(pa.Patches) != 10 {
+		t.Errorf("identical patches should have been deduplicated; expected 10, got %d", len(pa.Patches))
+	}
+	if err := pa.Err(); err != nil {
+		t.Errorf("expected no conflicts: %s", err)
+	}
+
+	pa.AppendPatch(Hunk{Name: "hunk-3", Body: []byte("something else\n")})
+	if len(pa.Patches) != 10 {
+		t.Errorf("conflicting patch should replace the earlier one, not be added; expected 10, got %d", len(pa.Patches))
+	}
+	for _, hunk := range pa.Patches {
+		if hunk.Name == "hunk-3" && string(hunk.Body) != "something else\n" {
+			t.Errorf("the last conflicting patch should win; got %q", hunk.Body)
+		}
+	}
+	if err := pa.Err(); err == nil {
+		t.Errorf("expected a conflict to be reported")
+	}
+}
+
+func TestPatchEmptyDocument(t *testing.T) {
+	doc := Patch(&Document{},
+		Hunk{Name: "one", Body: []byte("body\n")},
+		Hunk{Name: "two", InfoString: "json", Body: []byte("{}")},
+	)
+	reparsed, err := Parse([]byte(doc.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.DataHunks) != 2 || len(reparsed.DataHunks) != 2 {
+		t.Fatalf("expected 2 hunks, have %d (and %d after reparse)", len(doc.DataHunks), len(reparsed.DataHunks))
+	}
+	for i := range doc.DataHunks {
+		if doc.DataHunks[i].LineStart != reparsed.DataHunks[i].LineStart || doc.DataHunks[i].LineEnd != reparsed.DataHunks[i].LineEnd {
+			t.Errorf("hunk %q: offsets %d-%d don't match reparse %d-%d", doc.DataHunks[i].Name,
+				doc.DataHunks[i].LineStart, doc.DataHunks[i].LineEnd, reparsed.DataHunks[i].LineStart, reparsed.DataHunks[i].LineEnd)
+		}
+	}
+	if string(reparsed.HunksByName["one"].Body) != "body\n" {
+		t.Errorf("appended hunk body should round-trip; got %q", reparsed.HunksByName["one"].Body)
+	}
+}
+
+func TestPatchEmptyBody(t *testing.T) {
+	doc := Patch(&Document{}, Hunk{Name: "empty", Body: nil})
+	if s := doc.String(); s != "[testmark]:# (empty)\n```\n```\n" {
+		t.Errorf("an empty body shouldn't produce a blank line; got %q", s)
+	}
+	reparsed, err := Parse([]byte(doc.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := reparsed.HunksByName["empty"].Body; len(body) != 0 {
+		t.Errorf("empty body should round-trip; got %q", body)
+	}
+}
+
+func TestPatchAppendedThenRepatched(t *testing.T) {
+	doc, err := Parse([]byte("hello\n\n[testmark]:# (old)\n```\nold\n```\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc = Patch(doc, Hunk{Name: "new", Body: []byte("first\n")})
+	if _, exists := doc.HunksByName["new"]; !exists || len(doc.DataHunks) != 2 {
+		t.Fatalf("appended hunk should be indexed in DataHunks and HunksByName")
+	}
+	// Patching the appended hunk again should replace it, not append a second copy.
+	doc = Patch(doc, Hunk{Name: "new", Body: []byte("second\nand more\n")})
+	reparsed, err := Parse([]byte(doc.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reparsed.DataHunks) != 2 {
+		t.Fatalf("expected 2 hunks after repatching, have %d:\n%s", len(reparsed.DataHunks), doc.String())
+	}
+	if body := string(reparsed.HunksByName["new"].Body); body != "second\nand more\n" {
+		t.Errorf("repatched hunk body: got %q", body)
+	}
+}
+
+func TestSplitBodyLines(t *testing.T) {
+	for _, tc := range []struct {
+		body   string
+		expect int
+	}{
+		{"", 0},
+		{"one", 1},
+		{"one\n", 1},
+		{"one\ntwo\n", 2},
+		{"one\n\n", 2},
+	} {
+		if lines := splitBodyLines([]byte(tc.body)); len(lines) != tc.expect {
+			t.Errorf("splitBodyLines(%q): expected %d lines, got %d", tc.body, tc.expect, len(lines))
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package testmark
+
+import (
+	"bytes"
+)
+
+// Prune returns a new Document with the named hunks removed entirely --
+// the testmark comment line, the code block fences, and the body all go.
+//
+// Names that aren't present in the document are ignored.
+// Prose is otherwise left exactly where it was,
+// except that if removing a hunk would leave two blank lines in a row where there used to be a hunk between them,
+// one of those blank lines is dropped too (otherwise every prune would leave a little more whitespace behind).
+//
+// Like Patch, this doesn't mutate the old document; a new one is built.
+func Prune(oldDoc *Document, names ...string) (newDoc *Document) {
+	doomed := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		doomed[name] = struct{}{}
+	}
+
+	newDoc = &Document{
+		Lines:       make([][]byte, 0, len(oldDoc.Lines)),
+		DataHunks:   make([]DocHunk, 0, len(oldDoc.DataHunks)),
+		HunksByName: make(map[string]DocHunk, len(oldDoc.HunksByName)),
+	}
+
+	var leftOff int
+	for _, hunk := range oldDoc.DataHunks {
+		// Copy any prose lines from wherever we left off, up to the start of this hunk.
+		newDoc.Lines = append(newDoc.Lines, oldDoc.Lines[leftOff:hunk.LineStart]...)
+		leftOff = hunk.LineEnd + 1
+
+		if _, prune := doomed[hunk.Name]; prune {
+			// Skip the hunk's lines entirely.
+			// If that leaves us sandwiched between blank lines, eat the next one.
+			if l := len(newDoc.Lines); l > 0 && len(bytes.TrimSpace(newDoc.Lines[l-1])) == 0 &&
+				leftOff < len(oldDoc.Lines) && len(bytes.TrimSpace(oldDoc.Lines[leftOff])) == 0 &&
+				leftOff < len(oldDoc.Lines)-1 {
+				leftOff++
+			}
+			continue
+		}
+
+		// Keep the hunk, but note where it lands now, since the offsets may have moved.
+		newLineStart := len(newDoc.Lines)
+		newDoc.Lines = append(newDoc.Lines, oldDoc.Lines[hunk.LineStart:hunk.LineEnd+1]...)
+		docHunk := DocHunk{
+			LineStart: newLineStart,
+			LineEnd:   len(newDoc.Lines) - 1,
+			Hunk:      hunk.Hunk,
+		}
+		newDoc.DataHunks = append(newDoc.DataHunks, docHunk)
+		newDoc.HunksByName[hunk.Name] = docHunk
+	}
+
+	// Copy any remaining trailing prose lines.
+	newDoc.Lines = append(newDoc.Lines, oldDoc.Lines[leftOff:]...)
+
+	return
+}
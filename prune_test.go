@@ -0,0 +1,99 @@
+package testmark
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPrune(t *testing.T) {
+	testdata, err := filepath.Abs("testdata")
+	if err != nil {
+		panic(err)
+	}
+	doc, err := ReadFile(filepath.Join(testdata, "example.md"))
+	if err != nil {
+		panic(err)
+	}
+	doc = Prune(doc, "more-data", "no-such-hunk")
+	if len(doc.DataHunks) != 2 {
+		t.Fatalf("expected 2 hunks to remain, got %d", len(doc.DataHunks))
+	}
+	if _, exists := doc.HunksByName["more-data"]; exists {
+		t.Errorf("pruned hunk should be gone from the index")
+	}
+
+	// The result should reparse to the same hunks at the same offsets.
+	reparsed, err := Parse([]byte(doc.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, hunk := range doc.DataHunks {
+		if reparsed.DataHunks[i].Name != hunk.Name ||
+			reparsed.DataHunks[i].LineStart != hunk.LineStart ||
+			reparsed.DataHunks[i].LineEnd != hunk.LineEnd {
+			t.Errorf("hunk %d: offsets after prune %v do not match reparse %v", i, hunk, reparsed.DataHunks[i])
+		}
+	}
+}
+
+func TestPatchThenPrune(t *testing.T) {
+	testdata, err := filepath.Abs("testdata")
+	if err != nil {
+		panic(err)
+	}
+	doc, err := ReadFile(filepath.Join(testdata, "example.md"))
+	if err != nil {
+		panic(err)
+	}
+	doc = Patch(doc, Hunk{Name: "this-is-the-data-name", Body: []byte("one\ntwo\nthree\nfour\n")})
+	doc = Prune(doc, "more-data")
+	reparsed, err := Parse([]byte(doc.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reparsed.DataHunks) != 2 {
+		t.Fatalf("expected 2 hunks to remain, got %d", len(reparsed.DataHunks))
+	}
+	if string(reparsed.HunksByName["this-is-the-data-name"].Body) != "one\ntwo\nthree\nfour\n" {
+		t.Errorf("patched body was lost: %q", reparsed.HunksByName["this-is-the-data-name"].Body)
+	}
+	if string(reparsed.HunksByName["cannot-describe-no-linebreak"].Body) != string(doc.HunksByName["cannot-describe-no-linebreak"].Body) {
+		t.Errorf("untouched hunk was damaged")
+	}
+}
+
+// TestPatchThenPruneIndexes checks that the document Prune returns, after Patch, still knows where every hunk is,
+// including hunks Patch appended, and hunks it emptied -- as when regen both patches and prunes a fixture.
+func TestPatchThenPruneIndexes(t *testing.T) {
+	testdata, err := filepath.Abs("testdata")
+	if err != nil {
+		panic(err)
+	}
+	doc, err := ReadFile(filepath.Join(testdata, "example.md"))
+	if err != nil {
+		panic(err)
+	}
+	doc = Patch(doc,
+		Hunk{Name: "cannot-describe-no-linebreak", Body: nil},
+		Hunk{Name: "appended", Body: []byte("new\n")},
+	)
+	doc = Prune(doc, "more-data")
+	reparsed, err := Parse([]byte(doc.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.DataHunks) != len(reparsed.DataHunks) {
+		t.Fatalf("expected %d hunks, as on reparse, got %d", len(reparsed.DataHunks), len(doc.DataHunks))
+	}
+	for i, hunk := range doc.DataHunks {
+		if reparsed.DataHunks[i].Name != hunk.Name ||
+			reparsed.DataHunks[i].LineStart != hunk.LineStart ||
+			reparsed.DataHunks[i].LineEnd != hunk.LineEnd ||
+			string(reparsed.DataHunks[i].Body) != string(hunk.Body) {
+			t.Errorf("hunk %d: %v after patch and prune does not match reparse %v", i, hunk, reparsed.DataHunks[i])
+		}
+	}
+	if string(reparsed.HunksByName["appended"].Body) != "new\n" || len(reparsed.HunksByName["cannot-describe-no-linebreak"].Body) != 0 {
+		t.Errorf("patched bodies were lost:\n%s", doc.String())
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"os"
 	"path"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/warpfork/go-fsx"
@@ -35,6 +38,7 @@ func NewManager(fs fsx.FS) *Manager {
 //   - suite.Manager automatically names your tests based on the filename and hunk names.
 //   - suite.Manager automatically rigs up fixture regeneration for you when `-testmark.regen=true`.
 //   - suite.Manager will warn you about any hunks that go unused in a file (helps detect typos!).
+//   - suite.Manager can remove hunks that go unused, when `-testmark.regen` and `-testmark.prune` are both set.
 //   - suite.Manager will warn you about any hunk globs that go unmatched in a file.
 //
 // In general, using suite.Manager will help make sure your fixture files and test cases
@@ -50,9 +54,16 @@ type Manager struct {
 
 	workset map[string]fileContentExpectations
 
+	ignores []ignoreRule
+
 	disableFileParallelism bool
 }
 
+type ignoreRule struct {
+	files FilenameGlob
+	hunks HunkGlob
+}
+
 type fileContentExpectations struct {
 	filename string
 
@@ -157,9 +168,39 @@ func (sm *Manager) DisableFileParallelism() {
 //
 // Such a setup would allow you to put comment nodes even deep inside hunk trees that are used
 // by other test patterns (such as e.g. `testexec.TestPattern`) which would otherwise report them as an error.
-// func (sm *Manager) IgnoreUnrecognized(files FilenameGlob, pattern HunkGlob) {
-// panic("not yet implemented") // TODO
-// }
+//
+// The pattern also covers everything beneath a matched hunk path:
+// with the example above, "foo/comment/details" is ignored as well.
+// Ignored hunks are never removed by `-testmark.prune`.
+//
+// Both globs are per `path.Match`.  The filename glob is matched against filenames as they're run,
+// so unlike WorkWith, it's not an error if it matches nothing.
+// If either glob doesn't compile, IgnoreUnrecognized panics.
+func (sm *Manager) IgnoreUnrecognized(files FilenameGlob, pattern HunkGlob) {
+	if _, err := path.Match(string(files), ""); err != nil {
+		panic(fmt.Errorf("filename glob does not compile: %w", err))
+	}
+	if _, err := path.Match(string(pattern), ""); err != nil {
+		panic(fmt.Errorf("hunk label glob does not compile: %w", err))
+	}
+	sm.ignores = append(sm.ignores, ignoreRule{files, pattern})
+}
+
+// isIgnored returns true if the hunk name, or any of its parent paths, matches an IgnoreUnrecognized rule for this file.
+func (sm *Manager) isIgnored(filename string, hunkName string) bool {
+	segs := strings.Split(hunkName, testmark.HunkPathSeparator)
+	for _, rule := range sm.ignores {
+		if match, _ := path.Match(string(rule.files), filename); !match {
+			continue
+		}
+		for i := range segs {
+			if match, _ := path.Match(string(rule.hunks), strings.Join(segs[:i+1], testmark.HunkPathSeparator)); match {
+				return true
+			}
+		}
+	}
+	return false
+}
 
 // Run launches the test suite.
 // WorkWith should have been called to populate the suite before this.
@@ -174,6 +215,14 @@ func (sm *Manager) DisableFileParallelism() {
 // and within each file, the hunks are handled in the order they appear.
 // Call DisableFileParallelism if that parallelism is undesired.
 //
+// Unused or unrecognized hunks are reported in a "dangling references" subtest after the hunks' subtests,
+// and regenerated fixtures are written back when each file's test is cleaned up.
+//
+// TestingFunctors are free to call `t.Parallel` on the subtests they're given (or any they create).
+// If any of them do, the dangling references can't be known until those finish,
+// so the checks move into a `t.Cleanup` on the file's test, and report errors on the file's test itself
+// (subtests can't be started from a cleanup func).
+//
 // Calling Run more than one time is nonsensical.
 func (sm *Manager) Run(t *testing.T) {
 	for filename, fileContentExpectations := range sm.workset {
@@ -198,26 +247,49 @@ func (sm *Manager) Run(t *testing.T) {
 			}
 			tmDoc.BuildDirIndex()
 
-			// Prepare to write back patches, if appropriate.
+			// Prepare to gather patches, if regen was requested.
 			var patchAccum *testmark.PatchAccumulator
 			if *testmark.Regen {
 				patchAccum = &testmark.PatchAccumulator{}
-				defer func() {
-					f, err := fsx.OpenFile(sm.fs, filename, fsx.O_TRUNC|fsx.O_WRONLY, 0777)
-					if err != nil {
-						t.Fatalf("could not open file to write regenerated fixture: %s", err)
-					}
-					patchAccum.WriteWithPatches(tmDoc, f.(io.Writer))
-					f.Close()
-				}()
 			}
 
 			// Before we begin walking, prepare to remember which things are usedHunks... or explicitly flagged as unknown.
+			// These may be reported from parallel subtests, so they're guarded by a mutex.
+			var mu sync.Mutex
 			usedHunks := map[string]struct{}{}
 			unrecognizedHunks := map[string]string{}
 			usedGlobs := map[HunkGlob]struct{}{}
-			reportUse := func(hunkName string) { usedHunks[hunkName] = struct{}{} }
-			reportUnrecog := func(hunkName string, reason string) { unrecognizedHunks[hunkName] = reason }
+			reportUse := func(hunkName string) {
+				mu.Lock()
+				defer mu.Unlock()
+				usedHunks[hunkName] = struct{}{}
+			}
+			reportUnrecog := func(hunkName string, reason string) {
+				mu.Lock()
+				defer mu.Unlock()
+				unrecognizedHunks[hunkName] = reason
+			}
+
+			// Keep count of the hunk subtests that are still going, so we know if any called t.Parallel.
+			running := 0
+			danglingReported := false
+			var mode pruning
+
+			// Writing back regenerated fixtures needs to know what all the tests did,
+			// so it happens in a cleanup func, because that only runs after every subtest has finished, including any that called t.Parallel.
+			// If the dangling references couldn't be reported in a subtest (because some tests were still running), that happens here too.
+			t.Cleanup(func() {
+				mu.Lock()
+				defer mu.Unlock()
+				orphans := sm.unusedHunks(filename, tmDoc, usedHunks)
+				if !danglingReported {
+					mode = pruneMode(t.Failed())
+					sm.reportDangling(t, filename, tmDoc, fileContentExpectations, orphans, unrecognizedHunks, usedGlobs, mode)
+				}
+				if patchAccum != nil {
+					sm.writeBack(t, filename, tmDoc, patchAccum, orphans, mode == pruneNow)
+				}
+			})
 
 			// Range over the hunks, treating labels as if they're filesystem paths (e.g., "/" groups them).
 			// For every match, create a subtest with the hunk path as a name, and call the test functor's Run method.
@@ -227,6 +299,14 @@ func (sm *Manager) Run(t *testing.T) {
 					if match, _ := path.Match(string(hunkGlob), ent.Path); match {
 						usedGlobs[hunkGlob] = struct{}{}
 						t.Run(ent.Path, func(t *testing.T) {
+							mu.Lock()
+							running++
+							mu.Unlock()
+							defer func() {
+								mu.Lock()
+								running--
+								mu.Unlock()
+							}()
 							err := action.Run(t, filename, ent, reportUse, reportUnrecog, patchAccum)
 							if err != nil {
 								t.Fatalf("error while running the %s testing pattern on hunk %q in file %q: %s", action.Name(), ent.Path, filename, err)
@@ -238,29 +318,177 @@ func (sm *Manager) Run(t *testing.T) {
 
 			// Make another subtest to report any unused or explicitly unrecognized hunks.
 			// Only do this if there are any of them; otherwise, don't bother cluttering up the reports.
-			if len(tmDoc.HunksByName) == 0 ||
-				len(usedHunks) < len(tmDoc.HunksByName) ||
-				len(unrecognizedHunks) > 0 ||
-				len(usedGlobs) < len(fileContentExpectations.handlers) {
+			// If some of the hunk subtests are still running (they called t.Parallel), leave it to the cleanup func instead.
+			mu.Lock()
+			defer mu.Unlock()
+			if running > 0 {
+				return
+			}
+			danglingReported = true
+			mode = pruneMode(t.Failed())
+			orphans := sm.unusedHunks(filename, tmDoc, usedHunks)
+			if sm.anyDangling(filename, tmDoc, fileContentExpectations, orphans, unrecognizedHunks, usedGlobs) {
 				t.Run("dangling references", func(t *testing.T) {
-					if len(tmDoc.HunksByName) == 0 {
-						t.Errorf("file %q contained no testmark hunks at all and caused no tests to be exercised in this suite", filename)
-					}
-					for hunkName, _ := range tmDoc.HunksByName {
-						if _, exists := usedHunks[string(hunkName)]; !exists {
-							t.Errorf("hunk label %q in file %q was not used by any tests in this suite", hunkName, filename)
-						}
-					}
-					for hunkName, reason := range unrecognizedHunks {
-						t.Errorf("hunk label %q in file %q was flagged as unrecognized by one of the tests in this suite -- reason: %s", hunkName, filename, reason)
-					}
-					for hunkGlob, _ := range fileContentExpectations.handlers {
-						if _, exists := usedGlobs[hunkGlob]; !exists {
-							t.Errorf("the glob %q matched zero hunk labels in file %q and caused no tests to be exercised in this suite", hunkGlob, filename)
-						}
-					}
+					sm.reportDangling(t, filename, tmDoc, fileContentExpectations, orphans, unrecognizedHunks, usedGlobs, mode)
 				})
 			}
 		})
 	}
 }
+
+// pruning describes what's done with hunks that no test used.
+type pruning int
+
+const (
+	pruneNone     pruning = iota // They're errors.
+	pruneDryRun                  // They're logged, as what would be pruned.
+	pruneNow                     // They're logged, and removed from the file.
+	pruneHeldBack                // Pruning was asked for, but some of the file's tests failed, so they're logged, and kept.
+)
+
+// pruneMode works out what to do with unused hunks, per the flags.
+// Pruning (or a dry run of it) only applies together with `-testmark.regen`.
+// If any of a file's tests failed, nothing is pruned from it,
+// because a test that failed may have stopped before reporting the hunks it uses.
+func pruneMode(failed bool) pruning {
+	switch {
+	case !*testmark.Regen:
+		return pruneNone
+	case *testmark.PruneDryRun:
+		return pruneDryRun
+	case !*testmark.RegenPrune:
+		return pruneNone
+	case failed:
+		return pruneHeldBack
+	default:
+		return pruneNow
+	}
+}
+
+// unusedHunks returns the names (in document order) of the hunks that no test reported using,
+// and that aren't covered by an ignore rule.
+// These are the ones that get reported as dangling, or get pruned, if pruning is enabled.
+func (sm *Manager) unusedHunks(filename string, tmDoc *testmark.Document, usedHunks map[string]struct{}) (orphans []string) {
+	for _, hunk := range tmDoc.DataHunks {
+		if _, exists := usedHunks[hunk.Name]; exists {
+			continue
+		}
+		if sm.isIgnored(filename, hunk.Name) {
+			continue
+		}
+		orphans = append(orphans, hunk.Name)
+	}
+	return orphans
+}
+
+// anyDangling returns true if reportDangling would have anything to say.
+func (sm *Manager) anyDangling(
+	filename string,
+	tmDoc *testmark.Document,
+	fileContentExpectations fileContentExpectations,
+	orphans []string,
+	unrecognizedHunks map[string]string,
+	usedGlobs map[HunkGlob]struct{},
+) bool {
+	if len(tmDoc.HunksByName) == 0 || len(orphans) > 0 || len(usedGlobs) < len(fileContentExpectations.handlers) {
+		return true
+	}
+	for hunkName := range unrecognizedHunks {
+		if !sm.isIgnored(filename, hunkName) {
+			return true
+		}
+	}
+	return false
+}
+
+// reportDangling raises errors for any unused (orphans) or explicitly unrecognized hunks,
+// and any hunk globs that matched nothing.
+// If pruning (or a dry run of it) is enabled, the orphans are only logged, not errors.
+func (sm *Manager) reportDangling(
+	t *testing.T,
+	filename string,
+	tmDoc *testmark.Document,
+	fileContentExpectations fileContentExpectations,
+	orphans []string,
+	unrecognizedHunks map[string]string,
+	usedGlobs map[HunkGlob]struct{},
+	mode pruning,
+) {
+	if len(tmDoc.HunksByName) == 0 {
+		t.Errorf("file %q contained no testmark hunks at all and caused no tests to be exercised in this suite", filename)
+	}
+	for _, hunkName := range orphans {
+		switch mode {
+		case pruneNow:
+			t.Logf("hunk label %q in file %q was not used by any tests in this suite -- pruning it", hunkName, filename)
+		case pruneDryRun:
+			t.Logf("hunk label %q in file %q was not used by any tests in this suite -- would be pruned", hunkName, filename)
+		case pruneHeldBack:
+			t.Logf("hunk label %q in file %q was not used by any tests in this suite -- but not pruning it, because some of the file's tests failed", hunkName, filename)
+		default:
+			t.Errorf("hunk label %q in file %q was not used by any tests in this suite", hunkName, filename)
+		}
+	}
+	for hunkName, reason := range unrecognizedHunks {
+		if sm.isIgnored(filename, hunkName) {
+			continue
+		}
+		t.Errorf("hunk label %q in file %q was flagged as unrecognized by one of the tests in this suite -- reason: %s", hunkName, filename, reason)
+	}
+	for hunkGlob := range fileContentExpectations.handlers {
+		if _, exists := usedGlobs[hunkGlob]; !exists {
+			t.Errorf("the glob %q matched zero hunk labels in file %q and caused no tests to be exercised in this suite", hunkGlob, filename)
+		}
+	}
+}
+
+// writeBack applies any accumulated patches (and prunes orphans, if prune is true) and saves the file.
+// If there's nothing to change, the file isn't touched.
+//
+// If the suite's filesystem is backed by the OS, this uses testmark.UpdateFile,
+// which locks the file, re-reads it, and re-applies our changes onto whatever is there now --
+// so other tests (or other processes, e.g. other packages under `go test ./...`) regenerating the same file don't clobber each other.
+// Other filesystems just get the in-memory document written back to them.
+func (sm *Manager) writeBack(t *testing.T, filename string, tmDoc *testmark.Document, patchAccum *testmark.PatchAccumulator, orphans []string, prune bool) {
+	if err := patchAccum.Err(); err != nil {
+		t.Errorf("regenerating fixture %q: %s", filename, err)
+	}
+	if len(patchAccum.Patches) == 0 && (!prune || len(orphans) == 0) {
+		return
+	}
+	apply := func(doc *testmark.Document) *testmark.Document {
+		doc = testmark.Patch(doc, patchAccum.Patches...)
+		if prune {
+			doc = testmark.Prune(doc, orphans...)
+		}
+		return doc
+	}
+
+	// Check what kind of file we're dealing with.
+	f, err := sm.fs.Open(filename)
+	if err != nil {
+		t.Errorf("could not open file to write regenerated fixture: %s", err)
+		return
+	}
+	f.Close()
+	if osf, ok := f.(*os.File); ok {
+		err := testmark.UpdateFile(osf.Name(), func(doc *testmark.Document) (*testmark.Document, error) {
+			return apply(doc), nil
+		})
+		if err != nil {
+			t.Errorf("could not write regenerated fixture %q: %s", filename, err)
+		}
+		return
+	}
+
+	// Not an OS file: all we can do is write our own copy.
+	f, err = fsx.OpenFile(sm.fs, filename, fsx.O_TRUNC|fsx.O_WRONLY, 0777)
+	if err != nil {
+		t.Errorf("could not open file to write regenerated fixture: %s", err)
+		return
+	}
+	defer f.Close()
+	if _, err := testmark.Write(apply(tmDoc), f.(io.Writer)); err != nil {
+		t.Errorf("could not write regenerated fixture %q: %s", filename, err)
+	}
+}
@@ -0,0 +1,196 @@
+package suite_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/warpfork/go-testmark"
+	"github.com/warpfork/go-testmark/suite"
+)
+
+const fixture = "# fixture\n\n" +
+	"[testmark]:# (used)\n```\nok\n```\n\n" +
+	"[testmark]:# (unused)\n```\nstale\n```\n\n" +
+	"[testmark]:# (broken)\n```\nbad\n```\n\n" +
+	"[testmark]:# (comment)\n```\njust a note\n```\n"
+
+// memFS is an in-memory filesystem that the suite can write regenerated fixtures back to.
+type memFS struct {
+	fstest.MapFS
+}
+
+func (mem memFS) OpenFile(name string, flag int, perm fs.FileMode) (fs.File, error) {
+	return &memFile{mem: mem, name: name}, nil
+}
+
+func (mem memFS) Mkdir(name string, perm fs.FileMode) error {
+	return fmt.Errorf("memFS doesn't do directories")
+}
+
+// memFile collects writes, and stores them in the memFS when closed.
+type memFile struct {
+	bytes.Buffer
+	mem  memFS
+	name string
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return nil, fmt.Errorf("memFile can't stat") }
+func (f *memFile) Close() error {
+	f.mem.MapFS[f.name] = &fstest.MapFile{Data: f.Bytes(), Mode: 0644}
+	return nil
+}
+
+// useHunk reports using its hunk, unless it's set to fail first.
+type useHunk struct {
+	fail bool
+}
+
+func (u useHunk) Run(t *testing.T, filename string, subject *testmark.DirEnt, reportUse func(string), reportUnrecog func(string, string), patchAccum *testmark.PatchAccumulator) error {
+	if u.fail {
+		t.Fatalf("failing before reporting use of %q", subject.Path)
+	}
+	reportUse(subject.Path)
+	return nil
+}
+func (useHunk) Name() string          { return "useHunk" }
+func (useHunk) OwnsAllChildren() bool { return true }
+
+// regenHunk runs in parallel, and (under regen) patches its hunk to say it was regenerated.
+type regenHunk struct{}
+
+func (regenHunk) Run(t *testing.T, filename string, subject *testmark.DirEnt, reportUse func(string), reportUnrecog func(string, string), patchAccum *testmark.PatchAccumulator) error {
+	t.Parallel()
+	reportUse(subject.Path)
+	if patchAccum != nil {
+		patchAccum.AppendPatchIfBodyDiffers(*subject.Hunk, []byte("regenerated "+subject.Path+"\n"))
+	}
+	return nil
+}
+func (regenHunk) Name() string          { return "regenHunk" }
+func (regenHunk) OwnsAllChildren() bool { return true }
+
+// TestSuiteScenario only does anything when run by runScenario, in a subprocess,
+// so that the scenarios that are meant to fail can be checked.
+func TestSuiteScenario(t *testing.T) {
+	scenario := os.Getenv("SUITE_TEST_SCENARIO")
+	if scenario == "" {
+		return
+	}
+	mem := memFS{fstest.MapFS{"fixture.md": &fstest.MapFile{Data: []byte(fixture), Mode: 0644}}}
+	sm := suite.NewManager(mem)
+	sm.IgnoreUnrecognized("*", "comment")
+	if scenario == "parallel" {
+		for _, name := range []suite.HunkGlob{"used", "unused", "broken"} {
+			sm.MustWorkWith("fixture.md", name, regenHunk{})
+		}
+	} else {
+		sm.MustWorkWith("fixture.md", "used", useHunk{})
+		sm.MustWorkWith("fixture.md", "broken", useHunk{fail: scenario == "failing"})
+	}
+	t.Cleanup(func() {
+		fmt.Printf("---result---\n%s---end---\n", mem.MapFS["fixture.md"].Data)
+	})
+	sm.Run(t)
+}
+
+// runScenario runs TestSuiteScenario in a subprocess with the given flags,
+// and returns its output, the fixture as it was left, and whether the test failed.
+func runScenario(t *testing.T, scenario string, flags ...string) (output string, result string, failed bool) {
+	cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestSuiteScenario$", "-test.v"}, flags...)...)
+	cmd.Env = append(os.Environ(), "SUITE_TEST_SCENARIO="+scenario)
+	out, err := cmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		t.Fatal(err)
+	}
+	output = string(out)
+	start := strings.Index(output, "---result---\n")
+	end := strings.Index(output, "---end---")
+	if start < 0 || end < start {
+		t.Fatalf("scenario %q printed no result:\n%s", scenario, output)
+	}
+	return output, output[start+len("---result---\n") : end], err != nil
+}
+
+func TestDangling(t *testing.T) {
+	output, result, failed := runScenario(t, "passing")
+	if !failed {
+		t.Errorf("an unused hunk should fail the suite")
+	}
+	if !strings.Contains(output, "--- FAIL: TestSuiteScenario/fixture.md/dangling_references") {
+		t.Errorf("unused hunks should be reported in a dangling references subtest:\n%s", output)
+	}
+	if !strings.Contains(output, `hunk label "unused" in file "fixture.md" was not used`) || strings.Contains(output, `hunk label "comment"`) {
+		t.Errorf("only the unused hunk that isn't ignored should be reported:\n%s", output)
+	}
+	if result != fixture {
+		t.Errorf("the fixture shouldn't have changed; got:\n%s", result)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	output, result, failed := runScenario(t, "passing", "-testmark.regen", "-testmark.prune")
+	if failed {
+		t.Errorf("pruning should turn unused hunks into logs, not failures:\n%s", output)
+	}
+	if strings.Contains(result, "(unused)") {
+		t.Errorf("the unused hunk should have been pruned; got:\n%s", result)
+	}
+	for _, kept := range []string{"(used)", "(broken)", "(comment)"} {
+		if !strings.Contains(result, kept) {
+			t.Errorf("hunk %s should have been kept; got:\n%s", kept, result)
+		}
+	}
+}
+
+func TestPruneSkippedOnFailure(t *testing.T) {
+	output, result, failed := runScenario(t, "failing", "-testmark.regen", "-testmark.prune")
+	if !failed {
+		t.Errorf("the failing test should fail the suite")
+	}
+	if result != fixture {
+		t.Errorf("nothing should be pruned from a file with failures; got:\n%s", result)
+	}
+	if !strings.Contains(output, "not pruning it") {
+		t.Errorf("the unused hunks should be logged as not pruned:\n%s", output)
+	}
+}
+
+func TestPruneDryRun(t *testing.T) {
+	output, result, failed := runScenario(t, "passing", "-testmark.regen", "-testmark.prune.dryrun")
+	if failed {
+		t.Errorf("a dry run should turn unused hunks into logs, not failures:\n%s", output)
+	}
+	if !strings.Contains(output, `hunk label "unused" in file "fixture.md" was not used by any tests in this suite -- would be pruned`) {
+		t.Errorf("the dry run should list the unused hunk:\n%s", output)
+	}
+	if result != fixture {
+		t.Errorf("a dry run shouldn't change the fixture; got:\n%s", result)
+	}
+
+	// Without regen, a dry run doesn't apply, and unused hunks are still errors.
+	output, _, failed = runScenario(t, "passing", "-testmark.prune.dryrun")
+	if !failed || strings.Contains(output, "would be pruned") {
+		t.Errorf("a dry run without regen should change nothing about the failures:\n%s", output)
+	}
+}
+
+func TestParallelRegen(t *testing.T) {
+	output, result, failed := runScenario(t, "parallel", "-testmark.regen")
+	if failed {
+		t.Errorf("regenerating from parallel tests should pass:\n%s", output)
+	}
+	for _, name := range []string{"used", "unused", "broken"} {
+		if !strings.Contains(result, "[testmark]:# ("+name+")\n```\nregenerated "+name+"\n```\n") {
+			t.Errorf("hunk %q should have been patched by its parallel test; got:\n%s", name, result)
+		}
+	}
+	if !strings.Contains(result, "just a note") {
+		t.Errorf("the ignored hunk should have been left alone; got:\n%s", result)
+	}
+}
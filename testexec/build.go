@@ -0,0 +1,124 @@
+package testexec
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// buildKey identifies one build of a package.
+type buildKey struct {
+	importPath string
+	name       string
+	flags      string
+}
+
+type buildResult struct {
+	once sync.Once
+	dir  string
+	err  error
+}
+
+// builds caches the results of building packages, so that each is built only once per test binary.
+// (Failures are cached too, so every test that needs the package reports the same error, without rebuilding.)
+var builds = struct {
+	sync.Mutex
+	m map[buildKey]*buildResult
+}{m: map[buildKey]*buildResult{}}
+
+// buildPackages builds every package in BuildPackages (if it isn't built already),
+// and returns the directories containing the binaries, for putting on the PATH.
+func (tcfg Tester) buildPackages(flags ...string) ([]string, error) {
+	importPaths := make([]string, 0, len(tcfg.BuildPackages))
+	for importPath := range tcfg.BuildPackages {
+		importPaths = append(importPaths, importPath)
+	}
+	sort.Strings(importPaths)
+	var dirs []string
+	for _, importPath := range importPaths {
+		key := buildKey{importPath, tcfg.BuildPackages[importPath], strings.Join(flags, " ")}
+		builds.Lock()
+		result := builds.m[key]
+		if result == nil {
+			result = &buildResult{}
+			builds.m[key] = result
+		}
+		builds.Unlock()
+		result.once.Do(func() {
+			result.dir, result.err = buildPackage(key.importPath, key.name, flags)
+		})
+		if result.err != nil {
+			return nil, result.err
+		}
+		dirs = append(dirs, result.dir)
+	}
+	return dirs, nil
+}
+
+// buildPackage runs `go build` for one package, from the current directory (so, within the local module),
+// and without touching the network.
+// The binary is named for the command, and put in a directory of its own, within the user's cache directory.
+// That directory is reused across runs (and across test binaries), so the binary is replaced atomically.
+func buildPackage(importPath, name string, flags []string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("cannot build %q: command name %q must be a plain file name", importPath, name)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	cacheRoot, err := os.UserCacheDir()
+	if err != nil {
+		cacheRoot = os.TempDir()
+	}
+	hash := sha256.Sum256([]byte(strings.Join([]string{cwd, importPath, name, strings.Join(flags, " ")}, "\x00")))
+	dir := filepath.Join(cacheRoot, "testexec", "bin", hex.EncodeToString(hash[:8]))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	target := filepath.Join(dir, name)
+	if runtime.GOOS == "windows" {
+		target += ".exe"
+	}
+	tmp, err := ioutil.TempFile(dir, name+".tmp-")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	args := append([]string{"build", "-o", tmp.Name()}, flags...)
+	cmd := exec.Command(goTool(), append(args, importPath)...)
+	cmd.Env = append(os.Environ(), "GOPROXY=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("could not build %q (as command %q): %s\n%s", importPath, name, err, out)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		// On some platforms, a binary can't be replaced while it's running; if there's one there already, it'll have to do.
+		if _, statErr := os.Stat(target); statErr != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// goTool finds the go command of the toolchain that built this test binary, falling back to the one on the PATH.
+func goTool() string {
+	name := "go"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	path := filepath.Join(runtime.GOROOT(), "bin", name)
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	return "go"
+}
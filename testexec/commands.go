@@ -0,0 +1,139 @@
+package testexec
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// CommandFn is the outline of a command that can be run in-process, in the style of a program's main function.
+// args[0] is the command's name.  The returned int is the exit code.
+type CommandFn func(args []string, stdin io.Reader, stdout, stderr io.Writer) int
+
+// Commands is a set of commands that can be run in-process, by name.
+//
+// Use its ContextExecFn method as a Tester's ContextExecFn, and sequences will run those commands in-process,
+// without needing to build or install anything first.
+// Any other command is executed as usual, with ContextExecFn_Exec.
+//
+// Use its RunMain method in a TestMain function, and the commands can also be run as subprocesses --
+// so scripts, and other programs, can call them too.
+type Commands map[string]CommandFn
+
+// inProcessMu is held while an in-process command runs, because the process's working directory and environment are set for it.
+// (This only keeps in-process commands from running over each other; nothing else in the process is kept from seeing the changes.
+// That's why in-process commands can't be used in parallel tests.)
+var inProcessMu sync.Mutex
+
+// commandsBinDir is a directory of links to the test binary, one per command, if RunMain has set it up.
+// It's put at the front of the PATH of every test.
+var commandsBinDir string
+
+// ContextExecFn runs the command named by args[0] in-process, if it's one of these Commands;
+// otherwise, it falls back to ContextExecFn_Exec.
+//
+// The process's working directory and environment are set to the ExecContext's while an in-process command runs,
+// so that the command can read files and variables just like it would as a subprocess.
+// Because that affects the whole process, in-process commands can't be used with Tester.Parallel (it's an error);
+// and the test using them shouldn't call `t.Parallel` itself, either.
+// (Commands run via RunMain, as subprocesses, have no such restriction.)
+// In-process commands also can't be stopped by a timeout; they run until they return.
+func (cmds Commands) ContextExecFn(ctx ExecContext, args []string, stdout, stderr io.Writer) (exitcode int, oshit error) {
+	fn, exists := cmds[args[0]]
+	if !exists {
+		return ContextExecFn_Exec(ctx, args, stdout, stderr)
+	}
+	if ctx.parallel {
+		return -1000, fmt.Errorf("cannot run %q in-process in a parallel test: it needs to change the process's working directory and environment (don't use Tester.Parallel with in-process commands)", args[0])
+	}
+	inProcessMu.Lock()
+	defer inProcessMu.Unlock()
+	if ctx.Dir != "" {
+		retreat, err := os.Getwd()
+		if err != nil {
+			return -1000, err
+		}
+		if err := os.Chdir(ctx.Dir); err != nil {
+			return -1000, err
+		}
+		defer os.Chdir(retreat)
+	}
+	if ctx.Env != nil {
+		defer swapProcessEnv(ctx.Env)()
+	}
+	return fn(args, ctx.Stdin, stdout, stderr), nil
+}
+
+// RunMain is for use in a TestMain function, like this:
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(testexec.Commands{"mytool": mytool.Main}.RunMain(m))
+//	}
+//
+// If the test binary was invoked as one of the commands, RunMain runs that command (and returns its exit code).
+// Otherwise, it makes a temporary directory of links to the test binary, one named for each command,
+// puts that directory at the front of the PATH of every test, and runs the tests.
+func (cmds Commands) RunMain(m *testing.M) int {
+	name := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	if fn, exists := cmds[name]; exists {
+		return fn(os.Args, os.Stdin, os.Stdout, os.Stderr)
+	}
+	dir, err := cmds.linkBinaries()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "testexec: could not set up commands: %s\n", err)
+		return 2
+	}
+	defer os.RemoveAll(dir)
+	commandsBinDir = dir
+	defer func() { commandsBinDir = "" }()
+	return m.Run()
+}
+
+// linkBinaries makes a temporary directory containing a link to the test binary (or, failing that, a copy of it) for each command.
+func (cmds Commands) linkBinaries() (string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	dir, err := ioutil.TempDir("", "testexec-commands-")
+	if err != nil {
+		return "", err
+	}
+	for name := range cmds {
+		target := filepath.Join(dir, name)
+		if runtime.GOOS == "windows" {
+			target += ".exe"
+		}
+		if err := os.Symlink(self, target); err == nil {
+			continue
+		}
+		if err := copyFile(self, target); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("could not link or copy the test binary as %q: %w", name, err)
+		}
+	}
+	return dir, nil
+}
+
+func copyFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,17 @@
+package testexec
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCommandsRefuseParallel(t *testing.T) {
+	cmds := Commands{"noop": func(args []string, stdin io.Reader, stdout, stderr io.Writer) int { return 0 }}
+	var buf bytes.Buffer
+	_, err := cmds.ContextExecFn(ExecContext{Stdin: &buf, parallel: true}, []string{"noop"}, &buf, &buf)
+	if err == nil || !strings.Contains(err.Error(), "parallel") {
+		t.Errorf("expected an error about parallel tests, got %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package testexec
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// coverData is where the coverage data of the test cases is gathered, for writing each Tester.CoverProfile.
+// The raw data for a profile collects in a directory of its own until a top-level test finishes;
+// then it's merged into what was written to the profile before, the profile is rewritten, and the directory is removed.
+var coverData struct {
+	sync.Mutex
+	dirs     map[string]string        // Raw data not yet in the profile, by the profile's file name.
+	profiles map[string]*coverProfile // Everything written to each profile so far.
+}
+
+// testGocoverdir returns the directory that `go test -cover` asked the test binary to write coverage data to, if any.
+// (When there is one, go test merges everything in it into the coverage report, so that's where our data goes too.)
+func testGocoverdir() string {
+	if f := flag.Lookup("test.gocoverdir"); f != nil {
+		return f.Value.String()
+	}
+	return ""
+}
+
+// collectCoverage moves the coverage data written by a test case's commands into the test binary's coverage directory,
+// and into coverData, if a CoverProfile is wanted.
+func (tcfg Tester) collectCoverage(caseCoverDir string) error {
+	var dests []string
+	if dir := testGocoverdir(); dir != "" {
+		dests = append(dests, dir)
+	}
+	coverData.Lock()
+	defer coverData.Unlock()
+	if tcfg.CoverProfile != "" {
+		dir := coverData.dirs[tcfg.CoverProfile]
+		if dir == "" {
+			var err error
+			if dir, err = ioutil.TempDir("", "testexec-cover-"); err != nil {
+				return err
+			}
+			if coverData.dirs == nil {
+				coverData.dirs = map[string]string{}
+			}
+			coverData.dirs[tcfg.CoverProfile] = dir
+		}
+		dests = append(dests, dir)
+	}
+	files, err := ioutil.ReadDir(caseCoverDir)
+	if err != nil {
+		return err
+	}
+	for _, dest := range dests {
+		for _, fi := range files {
+			// Counter files have unique names; meta-data files are named for their content's hash, so an existing one is the same.
+			target := filepath.Join(dest, fi.Name())
+			if _, err := os.Stat(target); err == nil {
+				continue
+			}
+			if err := copyFile(filepath.Join(caseCoverDir, fi.Name()), target); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeCoverProfile merges the coverage data gathered since the last time into a text coverage profile (as `go test -coverprofile` writes),
+// along with everything written to the profile before, and removes the data.
+func writeCoverProfile(profile string) error {
+	coverData.Lock()
+	defer coverData.Unlock()
+	dir := coverData.dirs[profile]
+	if dir == "" {
+		return nil // No new coverage data.
+	}
+	delete(coverData.dirs, profile)
+	defer os.RemoveAll(dir)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+	text := dir + ".txt"
+	defer os.Remove(text)
+	out, err := exec.Command(goTool(), "tool", "covdata", "textfmt", "-i="+dir, "-o="+text).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("could not write coverage profile %q: %s\n%s", profile, err, out)
+	}
+	bs, err := ioutil.ReadFile(text)
+	if err != nil {
+		return err
+	}
+	cp := coverData.profiles[profile]
+	if cp == nil {
+		cp = &coverProfile{counts: map[string]int{}}
+		if coverData.profiles == nil {
+			coverData.profiles = map[string]*coverProfile{}
+		}
+		coverData.profiles[profile] = cp
+	}
+	if err := cp.merge(string(bs)); err != nil {
+		return fmt.Errorf("could not write coverage profile %q: %s", profile, err)
+	}
+	return ioutil.WriteFile(profile, []byte(cp.String()), 0666)
+}
+
+// coverProfile is the content of a text coverage profile.
+type coverProfile struct {
+	mode   string
+	blocks []string       // Each block, as "file:start,end statements", in the order they were first seen.
+	counts map[string]int // How many times each block ran.
+}
+
+// merge adds the blocks of a text coverage profile to this one.
+// The counts of blocks in both are added up (or, in "set" mode, where they're just 0 or 1, either one will do).
+func (cp *coverProfile) merge(text string) error {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	mode := strings.TrimPrefix(lines[0], "mode: ")
+	if mode == lines[0] {
+		return fmt.Errorf("missing the mode line")
+	}
+	if cp.mode != "" && cp.mode != mode {
+		return fmt.Errorf("can't merge coverage in %q mode with coverage in %q mode", mode, cp.mode)
+	}
+	cp.mode = mode
+	for _, line := range lines[1:] {
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			return fmt.Errorf("invalid line %q", line)
+		}
+		block := line[:i]
+		count, err := strconv.Atoi(line[i+1:])
+		if err != nil {
+			return fmt.Errorf("invalid line %q", line)
+		}
+		prev, seen := cp.counts[block]
+		if !seen {
+			cp.blocks = append(cp.blocks, block)
+		}
+		if mode != "set" {
+			count += prev
+		} else if prev > count {
+			count = prev
+		}
+		cp.counts[block] = count
+	}
+	return nil
+}
+
+// String formats the profile in the text coverage profile format.
+func (cp *coverProfile) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "mode: %s\n", cp.mode)
+	for _, block := range cp.blocks {
+		fmt.Fprintf(&sb, "%s %d\n", block, cp.counts[block])
+	}
+	return sb.String()
+}
@@ -0,0 +1,75 @@
+package testexec
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/warpfork/go-testmark"
+)
+
+func TestCoverProfileMerge(t *testing.T) {
+	for _, tc := range []struct {
+		mode   string
+		expect string
+	}{
+		{"set", "mode: set\na.go:1.1,2.2 1 1\nb.go:1.1,2.2 2 1\na.go:3.1,4.2 1 0\nc.go:1.1,2.2 1 0\n"},
+		{"count", "mode: count\na.go:1.1,2.2 1 2\nb.go:1.1,2.2 2 1\na.go:3.1,4.2 1 0\nc.go:1.1,2.2 1 0\n"},
+	} {
+		cp := &coverProfile{counts: map[string]int{}}
+		for _, text := range []string{
+			"mode: " + tc.mode + "\na.go:1.1,2.2 1 1\nb.go:1.1,2.2 2 0\na.go:3.1,4.2 1 0\n",
+			"mode: " + tc.mode + "\nc.go:1.1,2.2 1 0\na.go:1.1,2.2 1 1\nb.go:1.1,2.2 2 1\n",
+		} {
+			if err := cp.merge(text); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if actual := cp.String(); actual != tc.expect {
+			t.Errorf("%s mode: expected:\n%s\ngot:\n%s", tc.mode, tc.expect, actual)
+		}
+	}
+	cp := &coverProfile{counts: map[string]int{}}
+	cp.merge("mode: set\n")
+	if err := cp.merge("mode: count\n"); err == nil {
+		t.Errorf("expected an error merging profiles in different modes")
+	}
+}
+
+func TestCoverDataRemoved(t *testing.T) {
+	doc, err := testmark.Parse([]byte("[testmark]:# (cover/sequence)\n```\ngreet world\n```\n" +
+		"[testmark]:# (cover/then-again/sequence)\n```\ngreet again\n```\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.BuildDirIndex()
+	tcfg := Tester{
+		BuildPackages: map[string]string{"./testdata/greet": "greet"},
+		Cover:         true,
+		CoverProfile:  filepath.Join(t.TempDir(), "cover.out"),
+	}
+	for i := 0; i < 2; i++ {
+		var dir string
+		t.Run("cover", func(t *testing.T) {
+			tcfg.Test(t, doc.DirEnt.Children["cover"])
+			// The "then-again" subtest's coverage has been gathered by now, but the profile is only written once this test is done.
+			coverData.Lock()
+			defer coverData.Unlock()
+			dir = coverData.dirs[tcfg.CoverProfile]
+		})
+		if dir == "" {
+			t.Fatalf("expected coverage data to be gathered")
+		}
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("expected the coverage data to be removed once the profile was written, but stat says %v", err)
+		}
+		bs, err := os.ReadFile(tcfg.CoverProfile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(bs), "testexec/testdata/greet/main.go") {
+			t.Errorf("expected the profile to cover the greet command, got:\n%s", bs)
+		}
+	}
+}
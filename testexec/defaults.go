@@ -2,17 +2,89 @@ package testexec
 
 import (
 	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"syscall"
 	"testing"
+
+	"github.com/warpfork/go-testmark"
 )
 
+// ExecFn_Exec runs a command in the current working directory, with the current environment.
+// See ContextExecFn_Exec, which is the default if a Tester has no ExecFn.
 func ExecFn_Exec(args []string, stdin io.Reader, stdout, stderr io.Writer) (exitcode int, oshit error) {
-	cmd := exec.Command(args[0], args[1:]...)
-	cmd.Stdin = stdin
-	cmd.Stdout = stdout
-	cmd.Stderr = stderr
-	err := cmd.Run()
+	return ContextExecFn_Exec(ExecContext{Stdin: stdin}, args, stdout, stderr)
+}
+
+// ContextExecFn_Exec runs a command as a subprocess, in the ExecContext's directory and environment.
+// It never changes the current process's working directory, so it's safe for use in parallel tests.
+//
+// The command is started in a process group of its own (on unix-like platforms),
+// and the whole group is killed if the ExecContext's Context is done before the command finishes.
+// Anything the command left running in the background is killed as soon as the command finishes.
+//
+// An empty Dir means the current working directory, and a nil Env means the current environment.
+func ContextExecFn_Exec(ctx ExecContext, args []string, stdout, stderr io.Writer) (exitcode int, oshit error) {
+	// exec.Command looks up the command on this process's PATH; if the command will have a different PATH, look it up on that instead.
+	name := args[0]
+	if path, ok := lookupEnv(ctx.Env, "PATH"); ok && path != os.Getenv("PATH") && !strings.ContainsRune(name, filepath.Separator) {
+		found, err := lookPathIn(name, path, ctx.Dir)
+		if err != nil {
+			return -1000, err
+		}
+		name = found
+	}
+	cmd := exec.Command(name, args[1:]...)
+	cmd.Args[0] = args[0]
+	cmd.Dir = ctx.Dir
+	cmd.Env = ctx.Env
+	cmd.Stdin = ctx.Stdin
+	// Give the command pipes of our own for its output, rather than letting exec make them, so that Wait returns when the command exits,
+	// even if something it left running in the background still holds them open.  (That gets killed below, which closes them.)
+	var pipes outputPipes
+	var err error
+	if cmd.Stdout, err = pipes.to(stdout); err != nil {
+		pipes.abandon()
+		return -1000, err
+	}
+	if sameWriter(stdout, stderr) {
+		cmd.Stderr = cmd.Stdout // Share the pipe, like exec would, so the writer isn't written to concurrently.
+	} else if cmd.Stderr, err = pipes.to(stderr); err != nil {
+		pipes.abandon()
+		return -1000, err
+	}
+	setProcessGroup(cmd)
+	err = cmd.Start()
+	pipes.started()
+	if err == nil {
+		// If the context is done (e.g. on timeout) before the command finishes, kill the whole process group.
+		// Once Wait has returned, the watcher stops: the command has been reaped, so its pid (and group ID) may be reused by now.
+		done := make(chan struct{})
+		if ctx.Context != nil {
+			go func() {
+				select {
+				case <-ctx.Context.Done():
+					select {
+					case <-done:
+						return // Both happened; the command finished first, as far as we can tell, so leave it be.
+					default:
+					}
+					ctx.noteKilled()
+					killProcessGroup(cmd)
+				case <-done:
+				}
+			}()
+		}
+		err = cmd.Wait()
+		close(done)
+		killProcessGroupRemnants(cmd)
+	}
+	if copyErr := pipes.finish(); err == nil {
+		err = copyErr
+	}
 	// A bunch of processing is required to get typical unix error codes out of golang's exec system, unfortunately.
 	// We're going to:
 	// - Try to return the exit code, if we can parse it -- and then *not* return an error.
@@ -37,12 +109,127 @@ func ExecFn_Exec(args []string, stdin io.Reader, stdout, stderr io.Writer) (exit
 	return 0, nil
 }
 
+// outputPipes connects a command's output to writers that aren't files, through pipes that are copied from.
+type outputPipes struct {
+	writeEnds []*os.File
+	copies    []chan error
+}
+
+// to returns a file for the command to write to, whose contents go to w.
+// If w is already a file, it's used directly.
+func (p *outputPipes) to(w io.Writer) (io.Writer, error) {
+	if w == nil {
+		return nil, nil
+	}
+	if f, ok := w.(*os.File); ok {
+		return f, nil
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	p.writeEnds = append(p.writeEnds, pw)
+	copied := make(chan error, 1)
+	p.copies = append(p.copies, copied)
+	go func() {
+		_, err := io.Copy(w, pr)
+		pr.Close()
+		copied <- err
+	}()
+	return pw, nil
+}
+
+// sameWriter returns true if the two writers are the same one.
+// (Comparing interfaces panics if their values aren't comparable, in which case they aren't the same.)
+func sameWriter(a, b io.Writer) (same bool) {
+	defer func() { recover() }()
+	return a == b
+}
+
+// started closes our copies of the write ends, once the command has them (or has failed to start).
+func (p *outputPipes) started() {
+	for _, pw := range p.writeEnds {
+		pw.Close()
+	}
+	p.writeEnds = nil
+}
+
+// finish waits for everything written to the pipes to be copied,
+// which is once the command, and anything else holding the write ends, has exited.
+func (p *outputPipes) finish() error {
+	var err error
+	for _, copied := range p.copies {
+		if copyErr := <-copied; err == nil {
+			err = copyErr
+		}
+	}
+	return err
+}
+
+// abandon closes the pipes without running a command, and waits for their copying to end.
+func (p *outputPipes) abandon() {
+	p.started()
+	p.finish()
+}
+
+// ScriptFn_ExecBash runs a script with bash, in the current working directory, with the current environment.
+// See ContextScriptFn_ExecBash, which is the default if a Tester has no ScriptFn.
 func ScriptFn_ExecBash(script string, stdin io.Reader, stdout, stderr io.Writer) (exitcode int, oshit error) {
 	return ExecFn_Exec([]string{"bash", "-c", script}, stdin, stdout, stderr)
 }
 
+// ContextScriptFn_ExecBash runs a script with bash, in the ExecContext's directory and environment.
+func ContextScriptFn_ExecBash(ctx ExecContext, script string, stdout, stderr io.Writer) (exitcode int, oshit error) {
+	return ContextExecFn_Exec(ctx, []string{"bash", "-c", script}, stdout, stderr)
+}
+
 func defaultAssertFn(t *testing.T, actual, expect string) {
-	if actual != expect {
+	t.Helper()
+	switch {
+	case actual == expect:
+		return
+	case !strings.Contains(actual, "\n") && !strings.Contains(expect, "\n"):
+		// Short values (like exit codes) read better without diff decorations.
 		t.Errorf("expected: %q; actual: %q", expect, actual)
+	default:
+		t.Errorf("output did not match:\n%s", testmark.UnifiedDiff([]byte(expect), []byte(actual), testmark.DiffConfig{}))
+	}
+}
+
+func defaultAssertHunk(t *testing.T, actual string, filename string, expect testmark.DocHunk) {
+	t.Helper()
+	if actual != string(expect.Body) {
+		t.Errorf("output did not match:\n%s", testmark.UnifiedDiff(expect.Body, []byte(actual), testmark.HunkDiffConfig(filename, expect)))
+	}
+}
+
+// lookupEnv finds a variable in an environment in the style of `os.Environ()`.  The last one wins.
+func lookupEnv(env []string, key string) (value string, exists bool) {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, key+"=") {
+			value, exists = kv[len(key)+1:], true
+		}
+	}
+	return
+}
+
+// lookPathIn is like exec.LookPath, but searches the given PATH value, rather than this process's PATH.
+// Relative entries in the PATH are relative to the command's working directory (which is what workDir should be).
+func lookPathIn(name, path, workDir string) (string, error) {
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		candidate := filepath.Join(dir, name)
+		if !filepath.IsAbs(candidate) {
+			candidate = filepath.Join(workDir, candidate)
+			if !filepath.IsAbs(candidate) {
+				candidate = "." + string(filepath.Separator) + candidate // Otherwise exec.Command would search for it on PATH again.
+			}
+		}
+		if fi, err := os.Stat(candidate); err == nil && !fi.IsDir() && (runtime.GOOS == "windows" || fi.Mode()&0111 != 0) {
+			return candidate, nil
+		}
 	}
+	return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
 }
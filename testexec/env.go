@@ -0,0 +1,111 @@
+package testexec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// envRule is one line of an "env" hunk: either setting a variable, or unsetting it.
+type envRule struct {
+	key   string
+	value string
+	unset bool
+}
+
+// parseEnv parses the body of an "env" hunk.
+//
+// Each line is either `KEY=value`, which sets a variable, or `-KEY`, which unsets it.
+// The value is everything after the first "=", verbatim (there's no quoting),
+// except that placeholders (like "$WORK") are expanded.
+// Blank lines, and lines starting with "#", are ignored.
+func (tcfg Tester) parseEnv(body string) ([]envRule, error) {
+	var rules []envRule
+	for i, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+			continue
+		case strings.HasPrefix(trimmed, "-"):
+			rules = append(rules, envRule{key: trimmed[1:], unset: true})
+		case strings.Contains(line, "="):
+			eq := strings.Index(line, "=")
+			key := strings.TrimSpace(line[:eq])
+			if key == "" {
+				return nil, fmt.Errorf("line %d: missing variable name before \"=\"", i+1)
+			}
+			rules = append(rules, envRule{key: key, value: tcfg.expandPlaceholders(line[eq+1:])})
+		default:
+			return nil, fmt.Errorf("line %d: an env line must have the form `KEY=value` (or `-KEY` to unset)", i+1)
+		}
+	}
+	return rules, nil
+}
+
+// applyEnv applies the rules, in order, to the base environment (which is in the style of `os.Environ()`).
+// The result has no duplicate keys.
+func applyEnv(base []string, rules []envRule) []string {
+	var keys []string
+	values := map[string]string{}
+	set := func(key, value string) {
+		if _, exists := values[key]; !exists {
+			keys = append(keys, key)
+		}
+		values[key] = value
+	}
+	for _, kv := range base {
+		if eq := strings.Index(kv, "="); eq > 0 {
+			set(kv[:eq], kv[eq+1:])
+		}
+	}
+	for _, rule := range rules {
+		if rule.unset {
+			delete(values, rule.key)
+		} else {
+			set(rule.key, rule.value)
+		}
+	}
+	result := make([]string, 0, len(values))
+	for _, key := range keys {
+		if value, exists := values[key]; exists {
+			result = append(result, key+"="+value)
+		}
+	}
+	return result
+}
+
+// prependPath puts a directory at the front of the PATH in an environment (which is in the style of `os.Environ()`).
+func prependPath(env []string, dir string) []string {
+	path, exists := lookupEnv(env, "PATH")
+	if exists && path != "" {
+		dir += string(filepath.ListSeparator) + path
+	}
+	return applyEnv(env, []envRule{{key: "PATH", value: dir}})
+}
+
+// resolveCwd resolves the body of a "cwd" hunk to a directory within the test's temp directory.
+// The path must be relative, and must not climb out of the temp directory.
+func resolveCwd(workDir string, body string) (string, error) {
+	rel := filepath.Clean(filepath.FromSlash(strings.TrimSpace(body)))
+	if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%q must be a relative path within the test's filesystem", strings.TrimSpace(body))
+	}
+	return filepath.Join(workDir, rel), nil
+}
+
+// swapProcessEnv replaces the whole environment of this process, and returns a func that puts the old one back.
+// It's only for use with callbacks that can't take an ExecContext (and such tests are already not concurrency safe).
+func swapProcessEnv(env []string) (restore func()) {
+	prev := os.Environ()
+	replace := func(env []string) {
+		os.Clearenv()
+		for _, kv := range env {
+			if eq := strings.Index(kv, "="); eq > 0 {
+				os.Setenv(kv[:eq], kv[eq+1:])
+			}
+		}
+	}
+	replace(env)
+	return func() { replace(prev) }
+}
@@ -0,0 +1,118 @@
+package testexec
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/warpfork/go-testmark"
+)
+
+// fsSnapshot holds the contents of the regular files in a directory, keyed by slash-separated path relative to the directory.
+// It's taken before the commands run, so that the files they create or change can be told apart from the ones that were already there.
+type fsSnapshot map[string][]byte
+
+func snapshotFiles(dir string) (fsSnapshot, error) {
+	snap := fsSnapshot{}
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		snap[filepath.ToSlash(rel)] = body
+		return nil
+	})
+	return snap, err
+}
+
+// expectedFiles flattens an "expect-fs" DirEnt into the files it describes, mapping hunks to paths the same way createFiles does.
+// The paths are slash-separated, and listed in the order of the document.
+func expectedFiles(ent *testmark.DirEnt, prefix string, paths *[]string, ents map[string]*testmark.DirEnt) {
+	if ent.Hunk != nil && prefix != "" {
+		*paths = append(*paths, prefix)
+		ents[prefix] = ent
+	}
+	for _, child := range ent.ChildrenList {
+		childPath := child.Name
+		if prefix != "" {
+			childPath = prefix + "/" + child.Name
+		}
+		expectedFiles(child, childPath, paths, ents)
+	}
+}
+
+// checkFiles compares the files in the working directory with the "expect-fs" hunks.
+// Expected files must exist and have the expected content.
+// With Tester.StrictExpectFS, any other file that the commands created or changed is an error, too.
+// In regen mode, the expected hunks are patched, and hunks are added for any other files that the commands created or changed.
+func (tcfg Tester) checkFiles(t *testing.T, expectEnt *testmark.DirEnt, dir string, before fsSnapshot) {
+	t.Helper()
+	tcfg.reportUse(expectEnt.Path)
+	var paths []string
+	ents := map[string]*testmark.DirEnt{}
+	expectedFiles(expectEnt, "", &paths, ents)
+	after, err := snapshotFiles(dir)
+	if err != nil {
+		t.Errorf("could not read the files in the working directory: %s", err)
+		return
+	}
+	// Files the commands created or changed, which aren't expected.
+	var produced []string
+	for path, body := range after {
+		if prev, existed := before[path]; (!existed || !bytes.Equal(prev, body)) && ents[path] == nil {
+			produced = append(produced, path)
+		}
+	}
+	sort.Strings(produced)
+
+	check := func(t *testing.T) {
+		for _, path := range paths {
+			ent := ents[path]
+			tcfg.reportUse(ent.Path)
+			body, exists := after[path]
+			if !exists {
+				t.Errorf("expected file %q (from hunk %q) does not exist", path, ent.Path)
+				continue
+			}
+			actual, err := tcfg.prepareActual(body, ent.Hunk.Body)
+			if err != nil {
+				t.Errorf("hunk %q: %s", ent.Path, err)
+				continue
+			}
+			if *testmark.Regen {
+				tcfg.Patches.AppendPatchIfBodyDiffers(*ent.Hunk, actual)
+			} else {
+				tcfg.assertHunk(t, string(actual), ent)
+			}
+		}
+		switch {
+		case *testmark.Regen:
+			for _, path := range produced {
+				tcfg.Patches.AppendPatch(testmark.Hunk{
+					Name: expectEnt.Path + testmark.HunkPathSeparator + path,
+					Body: tcfg.filterOutput(tcfg.substitutePlaceholders(after[path])),
+				})
+			}
+		case tcfg.StrictExpectFS:
+			for _, path := range produced {
+				t.Errorf("unexpected file %q was created or changed (there's no %q hunk for it)", path, expectEnt.Path+testmark.HunkPathSeparator+path)
+			}
+		}
+	}
+	if *testmark.Regen {
+		// A missing file is still reported, because regen can't remove the hunk; that's up to you.
+		check(t)
+	} else {
+		t.Run("check-fs", check)
+	}
+}
@@ -0,0 +1,59 @@
+package testexec
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// filterRule is one line of a "filters" hunk: a regexp, and what to replace its matches with.
+type filterRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// parseFilters parses the body of a "filters" hunk.
+//
+// Each line is a rule of the form `regexp => replacement`.
+// The replacement may refer to capture groups (as `$1`, `${name}`, etc), as in regexp.Regexp.ReplaceAllString.
+// Whitespace around the "=>" is ignored; if the regexp itself contains "=>", the last one on the line is the separator.
+// Blank lines, and lines starting with "#", are ignored.
+func parseFilters(body string) ([]filterRule, error) {
+	var rules []filterRule
+	for i, line := range strings.Split(body, "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		sep := strings.LastIndex(line, "=>")
+		if sep < 0 {
+			return nil, fmt.Errorf("line %d: a filter rule must have the form `regexp => replacement`", i+1)
+		}
+		re, err := regexp.Compile(strings.TrimSpace(line[:sep]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		rules = append(rules, filterRule{re, strings.TrimSpace(line[sep+2:])})
+	}
+	return rules, nil
+}
+
+// filterOutput applies the FilterFn (if any), and then each of the filter rules, to each line of the output.
+func (tcfg Tester) filterOutput(bs []byte) []byte {
+	if tcfg.FilterFn == nil && len(tcfg.filters) == 0 {
+		return bs
+	}
+	lines := strings.Split(string(bs), "\n")
+	for i, line := range lines {
+		if i == len(lines)-1 && line == "" {
+			break // Just the end of the last line; nothing to filter.
+		}
+		if tcfg.FilterFn != nil {
+			line = tcfg.FilterFn(line)
+		}
+		for _, rule := range tcfg.filters {
+			line = rule.pattern.ReplaceAllString(line, rule.replacement)
+		}
+		lines[i] = line
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,54 @@
+package testexec
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// fileAttrs are the attributes of an "fs/*" hunk, given in its info string.
+type fileAttrs struct {
+	mode    os.FileMode // Zero means the default (0644 for files, 0755 for directories).
+	symlink string      // If set, the entry is a symlink to this target, rather than a file.
+	dir     bool        // If true, the entry is an (empty) directory, rather than a file.
+}
+
+// parseFileAttrs parses the info string of an "fs/*" hunk.
+// The attributes are `mode=0755` (an octal file mode), `symlink=target`, and `dir` (an empty directory).
+// Other bare words (such as the name of a language, for syntax highlighting) are ignored,
+// but an unknown `key=value` attribute is an error, since it's probably a typo.
+func parseFileAttrs(info string) (fileAttrs, error) {
+	var attrs fileAttrs
+	for _, word := range strings.Fields(info) {
+		eq := strings.Index(word, "=")
+		if eq < 0 {
+			if word == "dir" {
+				attrs.dir = true
+			}
+			continue
+		}
+		switch key, value := word[:eq], word[eq+1:]; key {
+		case "mode":
+			mode, err := strconv.ParseUint(value, 8, 32)
+			if err != nil || mode > 0777 {
+				return attrs, fmt.Errorf("mode %q must be an octal permission mode, like 0755", value)
+			}
+			attrs.mode = os.FileMode(mode)
+		case "symlink":
+			if value == "" {
+				return attrs, fmt.Errorf("symlink needs a target, like symlink=../target")
+			}
+			attrs.symlink = value
+		default:
+			return attrs, fmt.Errorf("unknown attribute %q (known attributes are mode=, symlink=, and dir)", key)
+		}
+	}
+	if attrs.dir && attrs.symlink != "" {
+		return attrs, fmt.Errorf("an entry can't be both a dir and a symlink")
+	}
+	if attrs.symlink != "" && attrs.mode != 0 {
+		return attrs, fmt.Errorf("a symlink can't have a mode")
+	}
+	return attrs, nil
+}
@@ -0,0 +1,44 @@
+package testexec
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// DefaultHermeticPath is the PATH used in hermetic mode, if Tester.HermeticPath isn't set.
+const DefaultHermeticPath = "/usr/local/bin:/usr/bin:/bin"
+
+// HermeticSourceDateEpoch is the SOURCE_DATE_EPOCH used in hermetic mode.
+// (It's 1980-01-01, rather than zero, because that's the earliest date some archive formats, like zip, can represent.)
+const HermeticSourceDateEpoch = "315532800"
+
+// hermeticDirs lays out a test case's directory in hermetic mode:
+// the working directory, a fresh home directory, and a fresh temp directory, side by side.
+// (They're kept apart so that neither HOME nor TMPDIR show up in the working directory.)
+func hermeticDirs(caseDir string) (work, home, tmp string) {
+	return filepath.Join(caseDir, "work"), filepath.Join(caseDir, "home"), filepath.Join(caseDir, "tmp")
+}
+
+// hermeticEnv builds the environment for hermetic mode.
+// It starts from nothing, passes through only the variables named in HermeticEnv (if they're set),
+// and then pins everything that commonly makes results vary from machine to machine.
+func (tcfg Tester) hermeticEnv(home, tmp string) []string {
+	var env []string
+	for _, key := range tcfg.HermeticEnv {
+		if value, exists := os.LookupEnv(key); exists {
+			env = append(env, key+"="+value)
+		}
+	}
+	path := tcfg.HermeticPath
+	if path == "" {
+		path = DefaultHermeticPath
+	}
+	return append(env,
+		"HOME="+home,
+		"TMPDIR="+tmp,
+		"PATH="+path,
+		"LC_ALL=C",
+		"TZ=UTC",
+		"SOURCE_DATE_EPOCH="+HermeticSourceDateEpoch,
+	)
+}
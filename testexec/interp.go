@@ -0,0 +1,605 @@
+package testexec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContextScriptFn_Interpret runs a script with testexec's own small built-in interpreter, rather than with a shell.
+// The interpreter runs in-process, so scripts don't depend on the host's bash or coreutils,
+// and behave the same on every machine.
+// (External commands can still be run; they're executed with ContextExecFn_Exec.)
+//
+// The language is a small subset of POSIX shell:
+//
+//   - Each line is a command.  A line ending in a backslash continues on the next line.  `#` starts a comment.
+//   - Words are split on whitespace; single quotes, double quotes, and backslash escapes work as in a shell.
+//   - `$VAR` and `${VAR}` are expanded (except within single quotes), and `$?` is the last exit code.
+//     An expanded value is always one word (there's no word splitting or globbing).
+//   - `cmd1 | cmd2` pipes, `&&` and `||` chain, and `;` separates commands.
+//   - Redirections: `> file`, `>> file`, `< file`, `2> file`, `2>> file`, and `2>&1`.
+//   - `NAME=value` on its own sets a variable (which is also exported to commands).
+//   - `! cmd` expects the command to fail.
+//
+// Like sequence mode, the script stops at the first command that fails (unless the failure is expected with `!`,
+// or handled with `&&` or `||`), and its exit code becomes the exit code of the script.
+//
+// The builtin commands are:
+//
+//   - `cd DIR` -- change the working directory.
+//   - `echo ARGS...` -- print the args, separated by spaces.
+//   - `cat [FILES...]` -- print the files (or stdin, if there are none).
+//   - `cmp FILE1 FILE2` -- fail (printing a diff) if the files differ.
+//   - `exists PATHS...` -- fail if any of the paths don't exist.
+//   - `mkdir [-p] DIRS...` -- make directories.
+//   - `rm [-r] [-f] PATHS...` -- remove files (or, with `-r`, whole directories).
+//   - `env [NAME=value...]` -- set variables; or with no args, print all the variables.
+//   - `stdout REGEXP` and `stderr REGEXP` -- fail unless the previous command's stdout (or stderr) matches the regexp.
+//     (The regexp is in multi-line mode, so `^` and `$` match at the start and end of each line.)
+//   - `exit [N]`, `true`, and `false`.
+func ContextScriptFn_Interpret(ctx ExecContext, script string, stdout, stderr io.Writer) (exitcode int, oshit error) {
+	lines, err := parseScript(script)
+	if err != nil {
+		fmt.Fprintf(stderr, "script: %s\n", err)
+		return 2, nil
+	}
+	in := &interpreter{
+		ctx:   ctx,
+		state: newInterpState(ctx),
+	}
+	for _, line := range lines {
+		status, stop := in.runLine(line, stdout, stderr)
+		if stop {
+			return status, nil
+		}
+	}
+	return in.state.lastExit, nil
+}
+
+// ---- parsing ----
+
+// scriptWord is a word of a script, in parts, because variables are only expanded when the command is run.
+type scriptWord []wordPart
+
+type wordPart struct {
+	text     string
+	variable bool // If true, text is the name of a variable to expand.
+}
+
+type scriptRedirect struct {
+	fd     int    // 0, 1, or 2.
+	op     string // "<", ">", ">>", or ">&1".
+	target scriptWord
+}
+
+type scriptCommand struct {
+	words     []scriptWord
+	redirects []scriptRedirect
+}
+
+type scriptPipeline struct {
+	negate bool
+	cmds   []scriptCommand
+}
+
+// scriptChain is pipelines joined by "&&" and "||".  (Chains are separated by ";", or by lines.)
+type scriptChain struct {
+	pipelines []scriptPipeline
+	ops       []string // ops[i] joins pipelines[i] and pipelines[i+1].
+}
+
+// scriptToken is either a word or an operator.
+type scriptToken struct {
+	word scriptWord
+	op   string // Non-empty for operators.
+}
+
+func parseScript(script string) ([][]scriptChain, error) {
+	var result [][]scriptChain
+	rawLines := strings.Split(script, "\n")
+	for i := 0; i < len(rawLines); i++ {
+		lineNum := i + 1
+		line := rawLines[i]
+		for strings.HasSuffix(line, "\\") && i+1 < len(rawLines) {
+			i++
+			line = line[:len(line)-1] + rawLines[i]
+		}
+		tokens, err := tokenizeScriptLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+		chains, err := parseChains(tokens)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		result = append(result, chains)
+	}
+	return result, nil
+}
+
+// tokenizeScriptLine splits a line into words and operators, handling quoting, and noting variables to expand.
+func tokenizeScriptLine(line string) ([]scriptToken, error) {
+	var tokens []scriptToken
+	var word scriptWord
+	inWord := false
+	lit := func(s string) {
+		if n := len(word); n > 0 && !word[n-1].variable {
+			word[n-1].text += s
+		} else {
+			word = append(word, wordPart{text: s})
+		}
+		inWord = true
+	}
+	endWord := func() {
+		if inWord {
+			tokens = append(tokens, scriptToken{word: word})
+		}
+		word, inWord = nil, false
+	}
+	// readVar reads a variable reference at line[i] (which is a "$"), and returns the index of the last char consumed.
+	readVar := func(i int) int {
+		rest := line[i+1:]
+		switch {
+		case strings.HasPrefix(rest, "{"):
+			if end := strings.IndexByte(rest, '}'); end > 1 {
+				word = append(word, wordPart{text: rest[1:end], variable: true})
+				inWord = true
+				return i + 1 + end
+			}
+		case strings.HasPrefix(rest, "?"):
+			word = append(word, wordPart{text: "?", variable: true})
+			inWord = true
+			return i + 1
+		default:
+			n := 0
+			for n < len(rest) && (rest[n] == '_' || rest[n] >= 'a' && rest[n] <= 'z' || rest[n] >= 'A' && rest[n] <= 'Z' || n > 0 && rest[n] >= '0' && rest[n] <= '9') {
+				n++
+			}
+			if n > 0 {
+				word = append(word, wordPart{text: rest[:n], variable: true})
+				inWord = true
+				return i + n
+			}
+		}
+		lit("$")
+		return i
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			endWord()
+		case c == '#' && !inWord:
+			endWord()
+			return tokens, nil
+		case c == '\\':
+			if i+1 >= len(line) {
+				return nil, fmt.Errorf("trailing backslash")
+			}
+			i++
+			lit(line[i : i+1])
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			lit(line[i+1 : i+1+end])
+			i += 1 + end
+		case c == '"':
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				switch {
+				case line[i] == '\\' && i+1 < len(line) && strings.IndexByte("\"\\$`", line[i+1]) >= 0:
+					i++
+					lit(line[i : i+1])
+				case line[i] == '$':
+					i = readVar(i)
+				default:
+					lit(line[i : i+1])
+				}
+			}
+			if i >= len(line) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+			inWord = true
+		case c == '$':
+			i = readVar(i)
+		case c == '|' || c == '&' || c == ';' || c == '<' || c == '>':
+			// A "2" right before a ">" is a file descriptor number, if it's a word all by itself.
+			fd := ""
+			if c == '>' && inWord && len(word) == 1 && !word[0].variable && word[0].text == "2" && i > 0 && line[i-1] == '2' {
+				fd = "2"
+				word, inWord = nil, false
+			}
+			endWord()
+			op := ""
+			for _, candidate := range []string{">&1", "||", "&&", ">>", "|", ";", "<", ">"} {
+				if candidate == ">&1" && fd != "2" {
+					continue // Only "2>&1" is supported.
+				}
+				if strings.HasPrefix(line[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unsupported operator %q (there are no background jobs)", string(c))
+			}
+			tokens = append(tokens, scriptToken{op: fd + op})
+			i += len(op) - 1
+		default:
+			lit(line[i : i+1])
+		}
+	}
+	endWord()
+	return tokens, nil
+}
+
+func parseChains(tokens []scriptToken) ([]scriptChain, error) {
+	var chains []scriptChain
+	var chain scriptChain
+	var pipeline scriptPipeline
+	var cmd scriptCommand
+	endCommand := func() error {
+		if len(cmd.words) == 0 {
+			if len(cmd.redirects) > 0 || len(pipeline.cmds) > 0 || pipeline.negate {
+				return fmt.Errorf("missing command")
+			}
+			return nil
+		}
+		pipeline.cmds = append(pipeline.cmds, cmd)
+		cmd = scriptCommand{}
+		return nil
+	}
+	endPipeline := func() error {
+		if err := endCommand(); err != nil {
+			return err
+		}
+		if len(pipeline.cmds) == 0 {
+			if len(chain.ops) > 0 {
+				return fmt.Errorf("missing command after %q", chain.ops[len(chain.ops)-1])
+			}
+			return nil
+		}
+		chain.pipelines = append(chain.pipelines, pipeline)
+		pipeline = scriptPipeline{}
+		return nil
+	}
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch tok.op {
+		case "":
+			if len(cmd.words) == 0 && len(pipeline.cmds) == 0 && len(tok.word) == 1 && !tok.word[0].variable && tok.word[0].text == "!" {
+				pipeline.negate = true
+				continue
+			}
+			cmd.words = append(cmd.words, tok.word)
+		case "|":
+			if len(cmd.words) == 0 {
+				return nil, fmt.Errorf("missing command before \"|\"")
+			}
+			if err := endCommand(); err != nil {
+				return nil, err
+			}
+		case "&&", "||":
+			if err := endPipeline(); err != nil {
+				return nil, err
+			}
+			if len(chain.pipelines) == len(chain.ops) {
+				return nil, fmt.Errorf("missing command before %q", tok.op)
+			}
+			chain.ops = append(chain.ops, tok.op)
+		case ";":
+			if err := endPipeline(); err != nil {
+				return nil, err
+			}
+			if len(chain.pipelines) > 0 {
+				chains = append(chains, chain)
+			}
+			chain = scriptChain{}
+		case "2>&1":
+			cmd.redirects = append(cmd.redirects, scriptRedirect{fd: 2, op: ">&1"})
+		default: // A redirection, which must be followed by a word.
+			if i+1 >= len(tokens) || tokens[i+1].op != "" {
+				return nil, fmt.Errorf("missing file name after %q", tok.op)
+			}
+			i++
+			r := scriptRedirect{fd: 1, op: strings.TrimPrefix(tok.op, "2"), target: tokens[i].word}
+			switch {
+			case tok.op == "<":
+				r.fd = 0
+			case strings.HasPrefix(tok.op, "2"):
+				r.fd = 2
+			}
+			cmd.redirects = append(cmd.redirects, r)
+		}
+	}
+	if err := endPipeline(); err != nil {
+		return nil, err
+	}
+	if len(chain.ops) > 0 && len(chain.pipelines) == len(chain.ops) {
+		return nil, fmt.Errorf("missing command after %q", chain.ops[len(chain.ops)-1])
+	}
+	if len(chain.pipelines) > 0 {
+		chains = append(chains, chain)
+	}
+	return chains, nil
+}
+
+// ---- running ----
+
+// interpState is the part of the interpreter's state that commands can change: the working directory, and variables.
+type interpState struct {
+	dir      string
+	vars     map[string]string
+	lastExit int
+}
+
+func newInterpState(ctx ExecContext) *interpState {
+	st := &interpState{dir: ctx.Dir, vars: map[string]string{}}
+	env := ctx.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	for _, kv := range env {
+		if eq := strings.Index(kv, "="); eq > 0 {
+			st.vars[kv[:eq]] = kv[eq+1:]
+		}
+	}
+	if st.dir == "" {
+		st.dir, _ = os.Getwd()
+	}
+	return st
+}
+
+func (st *interpState) clone() *interpState {
+	vars := make(map[string]string, len(st.vars))
+	for k, v := range st.vars {
+		vars[k] = v
+	}
+	return &interpState{dir: st.dir, vars: vars, lastExit: st.lastExit}
+}
+
+func (st *interpState) environ() []string {
+	env := make([]string, 0, len(st.vars))
+	for k, v := range st.vars {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
+
+func (st *interpState) expand(w scriptWord) string {
+	var sb strings.Builder
+	for _, part := range w {
+		switch {
+		case !part.variable:
+			sb.WriteString(part.text)
+		case part.text == "?":
+			sb.WriteString(strconv.Itoa(st.lastExit))
+		default:
+			sb.WriteString(st.vars[part.text])
+		}
+	}
+	return sb.String()
+}
+
+// path resolves a path relative to the working directory.
+func (st *interpState) path(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(st.dir, p)
+}
+
+type interpreter struct {
+	ctx   ExecContext
+	state *interpState
+
+	// The stdout and stderr of the last command (other than the `stdout` and `stderr` assertions), for those assertions to check.
+	lastStdout, lastStderr []byte
+}
+
+// errExit is used by the `exit` builtin to stop the script.
+type errExit struct{ code int }
+
+func (e errExit) Error() string { return fmt.Sprintf("exit %d", e.code) }
+
+// runLine runs one line of the script, and returns its exit status, and whether the script should stop.
+func (in *interpreter) runLine(chains []scriptChain, stdout, stderr io.Writer) (status int, stop bool) {
+	for _, chain := range chains {
+		ranLast := false
+		for i, pl := range chain.pipelines {
+			if i > 0 {
+				if (chain.ops[i-1] == "&&") != (status == 0) {
+					continue
+				}
+			}
+			var exit *errExit
+			status, exit = in.runPipeline(pl, stdout, stderr)
+			in.state.lastExit = status
+			if exit != nil {
+				return exit.code, true
+			}
+			ranLast = i == len(chain.pipelines)-1
+		}
+		// As with `set -e` in a shell, failures in a "&&" or "||" chain only stop the script if they're at the end of it.
+		if status != 0 && ranLast {
+			return status, true
+		}
+		if in.ctx.Context != nil && in.ctx.Context.Err() != nil {
+			return status, true
+		}
+	}
+	return status, false
+}
+
+func (in *interpreter) runPipeline(pl scriptPipeline, stdout, stderr io.Writer) (status int, exit *errExit) {
+	// Assertions about the last command's output don't count as the last command themselves.
+	isAssertion := len(pl.cmds) == 1 && len(pl.cmds[0].words) > 0 && func() bool {
+		name := in.state.expand(pl.cmds[0].words[0])
+		return name == "stdout" || name == "stderr"
+	}()
+	// Writes are serialized (with one lock for both), since stdout and stderr may well be the same buffer,
+	// and several commands (or a command's stdout and stderr) may be writing at once.
+	var capturedOut, capturedErr bytes.Buffer
+	mu := &sync.Mutex{}
+	if isAssertion {
+		stdout, stderr = &lockedWriter{mu, stdout}, &lockedWriter{mu, stderr}
+	} else {
+		stdout = &lockedWriter{mu, io.MultiWriter(stdout, &capturedOut)}
+		stderr = &lockedWriter{mu, io.MultiWriter(stderr, &capturedErr)}
+	}
+
+	if len(pl.cmds) == 1 {
+		status, exit = in.runCommand(in.state, pl.cmds[0], in.ctx.Stdin, stdout, stderr)
+	} else {
+		// Stages of a pipeline run concurrently, each with its own copy of the state (as in a shell's subshells).
+		statuses := make([]int, len(pl.cmds))
+		var wg sync.WaitGroup
+		var stdin io.Reader = in.ctx.Stdin
+		for i, cmd := range pl.cmds {
+			var out io.Writer = stdout
+			var pr *io.PipeReader
+			var pw *io.PipeWriter
+			if i < len(pl.cmds)-1 {
+				pr, pw = io.Pipe()
+				out = pw
+			}
+			wg.Add(1)
+			go func(i int, cmd scriptCommand, stdin io.Reader, out io.Writer, pw *io.PipeWriter) {
+				defer wg.Done()
+				statuses[i], _ = in.runCommand(in.state.clone(), cmd, stdin, out, stderr)
+				if pw != nil {
+					pw.Close()
+				}
+				// Let anything still writing to us know that nobody's reading anymore.
+				if r, ok := stdin.(*io.PipeReader); ok {
+					r.CloseWithError(errors.New("broken pipe"))
+				}
+			}(i, cmd, stdin, out, pw)
+			if pr != nil {
+				stdin = pr
+			}
+		}
+		wg.Wait()
+		status = statuses[len(statuses)-1]
+	}
+
+	if pl.negate {
+		if status == 0 {
+			status = 1
+		} else {
+			status = 0
+		}
+	}
+	if !isAssertion {
+		in.lastStdout, in.lastStderr = capturedOut.Bytes(), capturedErr.Bytes()
+	}
+	return status, exit
+}
+
+// lockedWriter serializes writes with a mutex (which may be shared with other lockedWriters).
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
+
+func (in *interpreter) runCommand(st *interpState, cmd scriptCommand, stdin io.Reader, stdout, stderr io.Writer) (status int, exit *errExit) {
+	args := make([]string, len(cmd.words))
+	for i, w := range cmd.words {
+		args[i] = st.expand(w)
+	}
+
+	// Apply redirections, in order.
+	for _, r := range cmd.redirects {
+		if r.op == ">&1" {
+			stderr = stdout
+			continue
+		}
+		target := st.path(st.expand(r.target))
+		var f *os.File
+		var err error
+		switch r.op {
+		case "<":
+			f, err = os.Open(target)
+		case ">":
+			f, err = os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		case ">>":
+			f, err = os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "%s\n", err)
+			return 1, nil
+		}
+		defer f.Close()
+		switch r.fd {
+		case 0:
+			stdin = f
+		case 1:
+			stdout = f
+		case 2:
+			stderr = f
+		}
+	}
+
+	// A lone assignment sets a variable.
+	if len(args) == 1 && len(cmd.words[0]) > 0 && !cmd.words[0][0].variable {
+		if eq := strings.Index(args[0], "="); eq > 0 && isVarName(args[0][:eq]) && strings.HasPrefix(cmd.words[0][0].text, args[0][:eq+1]) {
+			st.vars[args[0][:eq]] = args[0][eq+1:]
+			return 0, nil
+		}
+	}
+
+	if builtin, exists := interpBuiltins[args[0]]; exists {
+		status, err := builtin(in, st, args[1:], stdin, stdout, stderr)
+		var ee errExit
+		if errors.As(err, &ee) {
+			return ee.code, &ee
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", args[0], err)
+		}
+		return status, nil
+	}
+
+	ctx := in.ctx
+	ctx.Dir = st.dir
+	ctx.Env = st.environ()
+	ctx.Stdin = stdin
+	if ctx.Stdin == nil {
+		ctx.Stdin = bytes.NewReader(nil)
+	}
+	status, err := ContextExecFn_Exec(ctx, args, stdout, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", args[0], err)
+		return 127, nil
+	}
+	return status, nil
+}
+
+func isVarName(s string) bool {
+	for i, c := range s {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,223 @@
+package testexec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/warpfork/go-testmark"
+)
+
+// interpBuiltin is the outline of a builtin command of the script interpreter.
+// The args don't include the command name.
+// If an error is returned, it's printed to stderr (prefixed by the command name).
+type interpBuiltin func(in *interpreter, st *interpState, args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error)
+
+var interpBuiltins map[string]interpBuiltin
+
+func init() {
+	// (Assigned in init, because the `stdout` and `stderr` builtins refer to the interpreter, which refers to this table.)
+	interpBuiltins = map[string]interpBuiltin{
+		"cd":     builtinCd,
+		"echo":   builtinEcho,
+		"cat":    builtinCat,
+		"cmp":    builtinCmp,
+		"exists": builtinExists,
+		"mkdir":  builtinMkdir,
+		"rm":     builtinRm,
+		"env":    builtinEnv,
+		"stdout": builtinStdout,
+		"stderr": builtinStderr,
+		"exit":   builtinExit,
+		"true": func(*interpreter, *interpState, []string, io.Reader, io.Writer, io.Writer) (int, error) {
+			return 0, nil
+		},
+		"false": func(*interpreter, *interpState, []string, io.Reader, io.Writer, io.Writer) (int, error) {
+			return 1, nil
+		},
+	}
+}
+
+func builtinCd(in *interpreter, st *interpState, args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	if len(args) != 1 {
+		return 2, fmt.Errorf("usage: cd DIR")
+	}
+	dir := st.path(args[0])
+	if fi, err := os.Stat(dir); err != nil {
+		return 1, err
+	} else if !fi.IsDir() {
+		return 1, fmt.Errorf("%s: not a directory", args[0])
+	}
+	st.dir = dir
+	st.vars["PWD"] = dir
+	return 0, nil
+}
+
+func builtinEcho(in *interpreter, st *interpState, args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	_, err := fmt.Fprintln(stdout, strings.Join(args, " "))
+	return 0, err
+}
+
+func builtinCat(in *interpreter, st *interpState, args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	if len(args) == 0 {
+		_, err := io.Copy(stdout, stdin)
+		return 0, err
+	}
+	for _, name := range args {
+		f, err := os.Open(st.path(name))
+		if err != nil {
+			return 1, err
+		}
+		_, err = io.Copy(stdout, f)
+		f.Close()
+		if err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+func builtinCmp(in *interpreter, st *interpState, args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	if len(args) != 2 {
+		return 2, fmt.Errorf("usage: cmp FILE1 FILE2")
+	}
+	a, err := ioutil.ReadFile(st.path(args[0]))
+	if err != nil {
+		return 2, err
+	}
+	b, err := ioutil.ReadFile(st.path(args[1]))
+	if err != nil {
+		return 2, err
+	}
+	if bytes.Equal(a, b) {
+		return 0, nil
+	}
+	fmt.Fprintf(stderr, "cmp: %s and %s differ:\n%s", args[0], args[1], testmark.UnifiedDiff(a, b, testmark.DiffConfig{ExpectLabel: args[0], ActualLabel: args[1]}))
+	return 1, nil
+}
+
+func builtinExists(in *interpreter, st *interpState, args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	if len(args) == 0 {
+		return 2, fmt.Errorf("usage: exists PATHS...")
+	}
+	status := 0
+	for _, name := range args {
+		if _, err := os.Lstat(st.path(name)); err != nil {
+			fmt.Fprintf(stderr, "exists: %s does not exist\n", name)
+			status = 1
+		}
+	}
+	return status, nil
+}
+
+func builtinMkdir(in *interpreter, st *interpState, args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	parents := false
+	if len(args) > 0 && args[0] == "-p" {
+		parents, args = true, args[1:]
+	}
+	if len(args) == 0 {
+		return 2, fmt.Errorf("usage: mkdir [-p] DIRS...")
+	}
+	for _, name := range args {
+		var err error
+		if parents {
+			err = os.MkdirAll(st.path(name), 0755)
+		} else {
+			err = os.Mkdir(st.path(name), 0755)
+		}
+		if err != nil {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+func builtinRm(in *interpreter, st *interpState, args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	recursive, force := false, false
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && len(args[0]) > 1 {
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 'r', 'R':
+				recursive = true
+			case 'f':
+				force = true
+			default:
+				return 2, fmt.Errorf("unknown flag -%c (usage: rm [-r] [-f] PATHS...)", flag)
+			}
+		}
+		args = args[1:]
+	}
+	for _, name := range args {
+		var err error
+		if recursive {
+			if _, err = os.Lstat(st.path(name)); err == nil {
+				err = os.RemoveAll(st.path(name))
+			}
+		} else {
+			err = os.Remove(st.path(name))
+		}
+		if err != nil && !(force && os.IsNotExist(err)) {
+			return 1, err
+		}
+	}
+	return 0, nil
+}
+
+func builtinEnv(in *interpreter, st *interpState, args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	if len(args) == 0 {
+		env := st.environ()
+		sort.Strings(env)
+		for _, kv := range env {
+			fmt.Fprintln(stdout, kv)
+		}
+		return 0, nil
+	}
+	for _, kv := range args {
+		eq := strings.Index(kv, "=")
+		if eq <= 0 || !isVarName(kv[:eq]) {
+			return 2, fmt.Errorf("%q is not of the form NAME=value", kv)
+		}
+		st.vars[kv[:eq]] = kv[eq+1:]
+	}
+	return 0, nil
+}
+
+func builtinStdout(in *interpreter, st *interpState, args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	return matchOutput("stdout", in.lastStdout, args, stderr)
+}
+
+func builtinStderr(in *interpreter, st *interpState, args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	return matchOutput("stderr", in.lastStderr, args, stderr)
+}
+
+func matchOutput(which string, output []byte, args []string, stderr io.Writer) (int, error) {
+	if len(args) != 1 {
+		return 2, fmt.Errorf("usage: %s REGEXP", which)
+	}
+	re, err := regexp.Compile("(?m)" + args[0])
+	if err != nil {
+		return 2, err
+	}
+	if !re.Match(output) {
+		fmt.Fprintf(stderr, "%s: no match for %q in the last command's %s:\n%s", which, args[0], which, output)
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func builtinExit(in *interpreter, st *interpState, args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	code := st.lastExit
+	if len(args) > 0 {
+		var err error
+		if code, err = strconv.Atoi(args[0]); err != nil {
+			return 2, fmt.Errorf("%q is not an exit code", args[0])
+		}
+	}
+	return code, errExit{code}
+}
@@ -0,0 +1,117 @@
+package testexec
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// placeholder is a dynamic value which is rewritten into a stable name (like "$WORK") in captured output.
+type placeholder struct {
+	name  string // Without the "$".
+	value string
+}
+
+// placeholdersFor gathers the placeholders for a test case running in the given working directory:
+// "$WORK", "$HOME" (in hermetic mode, or with Tester.HomePlaceholder), "$TMPDIR" (only in hermetic mode),
+// "$COMMANDS" (only if Commands.RunMain is in use), and any custom ones from Tester.Placeholders.
+// If homeDir is empty (i.e. not in hermetic mode), and Tester.HomePlaceholder is set, the user's home directory is used.
+//
+// Longer values are first, so that a value containing another (like a tempdir under the home dir)
+// is replaced as a whole.
+// Where a path has a different form once symlinks are resolved (e.g. "/tmp" on macOS), both forms are included.
+func (tcfg Tester) placeholdersFor(workDir, homeDir, tmpDir string) []placeholder {
+	var result []placeholder
+	add := func(name, value string) {
+		// Values that are empty, or just "/", would make a hash of everything; skip them.
+		if value == "" || value == string(filepath.Separator) {
+			return
+		}
+		result = append(result, placeholder{name, value})
+		if resolved, err := filepath.EvalSymlinks(value); err == nil && resolved != value {
+			result = append(result, placeholder{name, resolved})
+		}
+	}
+	if workDir != "" {
+		add("WORK", workDir)
+	}
+	if homeDir != "" {
+		add("HOME", homeDir)
+	} else if tcfg.HomePlaceholder {
+		if home, err := os.UserHomeDir(); err == nil {
+			add("HOME", home)
+		}
+	}
+	if tmpDir != "" {
+		add("TMPDIR", tmpDir)
+	}
+	if commandsBinDir != "" {
+		add("COMMANDS", commandsBinDir)
+	}
+	names := make([]string, 0, len(tcfg.Placeholders))
+	for name := range tcfg.Placeholders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(name, tcfg.Placeholders[name])
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return len(result[i].value) > len(result[j].value)
+	})
+	return result
+}
+
+// substitutePlaceholders replaces each placeholder's value in the output with its "$NAME".
+//
+// A value is only replaced where it ends at a boundary: that is, where it's not followed by something that would continue
+// a file name (a letter, digit, '.', '_', or '-').  So, with a home dir of "/root", "/root/x" and "/root: x" are replaced,
+// but "/rootfs" is left alone.
+// Where values overlap, the longest one (with a boundary) wins.
+func (tcfg Tester) substitutePlaceholders(bs []byte) []byte {
+	if len(tcfg.placeholders) == 0 {
+		return bs
+	}
+	s := string(bs)
+	var sb strings.Builder
+	sb.Grow(len(s))
+next:
+	for i := 0; i < len(s); {
+		for _, ph := range tcfg.placeholders { // (These are sorted longest first.)
+			if strings.HasPrefix(s[i:], ph.value) && (i+len(ph.value) == len(s) || !continuesFilename(s[i+len(ph.value)])) {
+				sb.WriteString("$" + ph.name)
+				i += len(ph.value)
+				continue next
+			}
+		}
+		sb.WriteByte(s[i])
+		i++
+	}
+	return []byte(sb.String())
+}
+
+func continuesFilename(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-'
+}
+
+// expandPlaceholders is the inverse of substitutePlaceholders, for use on the args of sequences:
+// any "$NAME" (or "${NAME}") that names a placeholder is replaced by its value.
+// Anything else that looks like a variable is left as it was.
+func (tcfg Tester) expandPlaceholders(s string) string {
+	if !strings.Contains(s, "$") {
+		return s
+	}
+	return placeholderRef.ReplaceAllStringFunc(s, func(ref string) string {
+		name := strings.Trim(ref, "${}")
+		for _, ph := range tcfg.placeholders {
+			if ph.name == name {
+				return ph.value
+			}
+		}
+		return ref
+	})
+}
+
+var placeholderRef = regexp.MustCompile(`\$(?:\{\w+\}|\w+)`)
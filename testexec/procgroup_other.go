@@ -0,0 +1,19 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package testexec
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup does nothing on this platform.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup only kills the command itself on this platform (not anything it started).
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
+
+// killProcessGroupRemnants does nothing on this platform (there's no process group to find the remnants by).
+func killProcessGroupRemnants(cmd *exec.Cmd) {}
@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package testexec_test
+
+import (
+	"testing"
+)
+
+// checkProcessGone does nothing on this platform, where there's no process group to kill the remnants of a command by.
+func checkProcessGone(t *testing.T, pid int) {}
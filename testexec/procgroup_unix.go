@@ -0,0 +1,32 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package testexec
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes the command start in a new process group of its own,
+// so that it, and anything it starts, can be killed together.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// killProcessGroup kills the whole process group started by the command.
+// It must only be called before the command has been waited for, since after that, the process group ID may be reused.
+func killProcessGroup(cmd *exec.Cmd) {
+	// The process group ID is the same as the pid of the command, since it started the group.
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// killProcessGroupRemnants kills anything left in the process group started by the command, after the command has been waited for.
+// The process group ID can't be reused while anything is still in the group.
+// (If nothing is, the ID might have been reused already; calling this right after Wait keeps the chance of that small.)
+func killProcessGroupRemnants(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
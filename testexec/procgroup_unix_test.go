@@ -0,0 +1,25 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package testexec_test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// checkProcessGone fails the test if the process still exists (and isn't just a zombie, which nothing may ever reap, if it was orphaned).
+// If it does still exist, it's killed.
+func checkProcessGone(t *testing.T, pid int) {
+	if syscall.Kill(pid, 0) != nil {
+		return
+	}
+	if stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid)); err == nil && strings.Contains(string(stat), ") Z ") {
+		return
+	}
+	syscall.Kill(pid, syscall.SIGKILL)
+	t.Errorf("process %d should have been killed when the test ended", pid)
+}
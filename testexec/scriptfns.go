@@ -0,0 +1,95 @@
+package testexec
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// defaultScriptFns are the ScriptFns for the info strings that are understood without any configuration.
+// Tester.ScriptFns can add to these, or replace them.
+var defaultScriptFns = map[string]ContextScriptFn{
+	"bash":   ContextScriptFn_ExecBash,
+	"sh":     ContextScriptFn_ExecSh,
+	"python": ContextScriptFn_ExecPython,
+	"go":     ContextScriptFn_GoRun,
+}
+
+func scriptFnKey(info string) string {
+	fields := strings.Fields(info)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToLower(fields[0])
+}
+
+// ContextScriptFn_ExecSh runs a script with sh, in the ExecContext's directory and environment.
+func ContextScriptFn_ExecSh(ctx ExecContext, script string, stdout, stderr io.Writer) (exitcode int, oshit error) {
+	return ContextExecFn_Exec(ctx, []string{"sh", "-c", script}, stdout, stderr)
+}
+
+// ContextScriptFn_ExecPython runs a script with python3, in the ExecContext's directory and environment.
+func ContextScriptFn_ExecPython(ctx ExecContext, script string, stdout, stderr io.Writer) (exitcode int, oshit error) {
+	return ContextExecFn_Exec(ctx, []string{"python3", "-c", script}, stdout, stderr)
+}
+
+// ContextScriptFn_GoRun runs a script that's a Go program (a whole "package main" file) with `go run`,
+// in the ExecContext's directory and environment.
+// The program is written to a file in a temp directory of its own (so it doesn't turn up in the working directory).
+// Note that a compile error exits with `go run`'s exit code, which is 1, as is usual for a failure.
+func ContextScriptFn_GoRun(ctx ExecContext, script string, stdout, stderr io.Writer) (exitcode int, oshit error) {
+	dir, err := ioutil.TempDir("", "testexec-gorun-")
+	if err != nil {
+		return -1000, err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "main.go")
+	if err := ioutil.WriteFile(file, []byte(script), 0644); err != nil {
+		return -1000, err
+	}
+	return ContextExecFn_Exec(ctx, []string{goTool(), "run", file}, stdout, stderr)
+}
+
+// scriptFnFor picks the ScriptFn for a "script" hunk, by its info string:
+// no info string means the Tester's own ContextScriptFn (or ScriptFn);
+// otherwise, Tester.ScriptFns is consulted first, then the defaults.
+// But "bash" and "sh" (which are often on a code fence just for syntax highlighting) go to the Tester's own ContextScriptFn (or ScriptFn),
+// if it was set explicitly, unless Tester.ScriptFns says otherwise.
+func (tcfg Tester) scriptFnFor(info string) (ContextScriptFn, error) {
+	own := tcfg.ContextScriptFn
+	if own == nil {
+		own = func(ctx ExecContext, script string, stdout, stderr io.Writer) (int, error) {
+			return tcfg.ScriptFn(script, ctx.Stdin, stdout, stderr)
+		}
+	}
+	key := scriptFnKey(info)
+	if key == "" {
+		return own, nil
+	}
+	for name, fn := range tcfg.ScriptFns {
+		if strings.ToLower(name) == key {
+			return fn, nil
+		}
+	}
+	if (key == "bash" || key == "sh") && !tcfg.defaultScriptFn {
+		return own, nil
+	}
+	if fn, ok := defaultScriptFns[key]; ok {
+		return fn, nil
+	}
+	known := make([]string, 0, len(defaultScriptFns)+len(tcfg.ScriptFns))
+	for name := range defaultScriptFns {
+		known = append(known, name)
+	}
+	for name := range tcfg.ScriptFns {
+		if _, dup := defaultScriptFns[strings.ToLower(name)]; !dup {
+			known = append(known, strings.ToLower(name))
+		}
+	}
+	sort.Strings(known)
+	return nil, fmt.Errorf("there's no ScriptFn for the info string %q (known: %s; see Tester.ScriptFns)", key, strings.Join(known, ", "))
+}
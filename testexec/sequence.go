@@ -0,0 +1,170 @@
+package testexec
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sequenceLine is one command of a sequence, along with any exit code annotation it had.
+//
+// A line of a sequence may start with an annotation saying what exit code the command is expected to have:
+// `! cmd` expects any non-zero exit code, and `[exit 2] cmd` expects exactly 2.
+type sequenceLine struct {
+	lineIdx int    // Index of the line within the hunk body.
+	rest    string // The command, without the annotation.
+
+	annotated bool
+	expectAny bool // True for a `!` annotation (any non-zero exit code).
+	expect    int  // For an `[exit N]` annotation.
+}
+
+var exitAnnotationRegexp = regexp.MustCompile(`^\[exit (-?[0-9]+)\]\s*`)
+
+// parseSequenceLines finds the commands in a sequence hunk's body, and their annotations.  Blank lines are skipped.
+func parseSequenceLines(body string) []sequenceLine {
+	var result []sequenceLine
+	for i, line := range strings.Split(body, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		sl := sequenceLine{lineIdx: i, rest: line}
+		trimmed := strings.TrimLeft(line, " \t")
+		if m := exitAnnotationRegexp.FindStringSubmatch(trimmed); m != nil {
+			sl.annotated = true
+			sl.expect, _ = strconv.Atoi(m[1])
+			sl.rest = trimmed[len(m[0]):]
+		} else if strings.HasPrefix(trimmed, "!") && len(trimmed) > 1 && (trimmed[1] == ' ' || trimmed[1] == '\t') {
+			sl.annotated = true
+			sl.expectAny = true
+			sl.rest = strings.TrimLeft(trimmed[1:], " \t")
+		}
+		result = append(result, sl)
+	}
+	return result
+}
+
+// sequenceResult records what happened when a sequence line was run.
+type sequenceResult struct {
+	line     sequenceLine
+	exitcode int
+	explicit bool // True if the expected exit code was explicit (an annotation, or an entry in the "exitcodes" hunk).
+	expected int  // The explicitly expected exit code (if not expectAny).
+	mismatch bool // True if the exit code wasn't what was expected.
+}
+
+func (sr sequenceResult) describeExpectation() string {
+	if sr.line.expectAny {
+		return "a non-zero exit code"
+	}
+	return fmt.Sprintf("exit code %d", sr.expected)
+}
+
+// parseExitcodes parses the body of an "exitcodes" hunk: one exit code per line.
+func parseExitcodes(body string) ([]int, error) {
+	var result []int
+	for i, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		code, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %q is not an exit code", i+1, line)
+		}
+		result = append(result, code)
+	}
+	return result, nil
+}
+
+// formatExitcodes produces the body of an "exitcodes" hunk, from the results of running a sequence.
+func formatExitcodes(results []sequenceResult) string {
+	var sb strings.Builder
+	for _, res := range results {
+		fmt.Fprintf(&sb, "%d\n", res.exitcode)
+	}
+	return sb.String()
+}
+
+// reannotateSequence produces an updated sequence hunk body, where every annotation of a line that was run
+// now matches the exit code that line actually had.
+// (Lines without annotations are left alone, as are lines that weren't run, and lines whose annotation was already right.)
+//
+// A `!` annotation is kept if the exit code was non-zero; otherwise annotations become `[exit N]`,
+// or are removed entirely if the exit code was zero.
+func reannotateSequence(body string, results []sequenceResult) string {
+	lines := strings.Split(body, "\n")
+	for _, res := range results {
+		if !res.line.annotated || !res.mismatch {
+			continue
+		}
+		indent := lines[res.line.lineIdx][:len(lines[res.line.lineIdx])-len(strings.TrimLeft(lines[res.line.lineIdx], " \t"))]
+		switch {
+		case res.exitcode == 0:
+			lines[res.line.lineIdx] = indent + res.line.rest
+		case res.line.expectAny:
+			lines[res.line.lineIdx] = indent + "! " + res.line.rest
+		default:
+			lines[res.line.lineIdx] = indent + fmt.Sprintf("[exit %d] ", res.exitcode) + res.line.rest
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// splitShellWords splits a line into words the way a POSIX shell would, but without doing any expansions.
+//
+// Outside of quotes, whitespace separates words, and a backslash makes the next character literal.
+// Within single quotes, everything is literal.
+// Within double quotes, a backslash only escapes `"`, `\`, `$`, and "`" (otherwise it's literal).
+// Quotes can be used to make an empty word (as "" does).
+func splitShellWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '\\':
+			if i+1 >= len(line) {
+				return nil, fmt.Errorf("trailing backslash")
+			}
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			word.WriteString(line[i+1 : i+1+end])
+			i += 1 + end
+			inWord = true
+		case c == '"':
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) && strings.IndexByte("\"\\$`", line[i+1]) >= 0 {
+					i++
+				}
+				word.WriteByte(line[i])
+			}
+			if i >= len(line) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,53 @@
+package testexec
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReannotateSequence(t *testing.T) {
+	body := "setup\n! expect-fail\n[exit 2] expect-two\n  ! was-fine\nnot-run\n"
+	lines := parseSequenceLines(body)
+	results := []sequenceResult{
+		{line: lines[0], exitcode: 0},
+		{line: lines[1], exitcode: 5},                                 // Still non-zero; left alone.
+		{line: lines[2], exitcode: 3, explicit: true, mismatch: true}, // Updated.
+		{line: lines[3], exitcode: 0, explicit: true, mismatch: true}, // Annotation removed.
+	}
+	expect := "setup\n! expect-fail\n[exit 3] expect-two\n  was-fine\nnot-run\n"
+	if actual := reannotateSequence(body, results); actual != expect {
+		t.Errorf("expected %q, got %q", expect, actual)
+	}
+	if lines[1].rest != "expect-fail" || !lines[1].expectAny {
+		t.Errorf("`!` annotation not parsed: %#v", lines[1])
+	}
+	if lines[2].rest != "expect-two" || lines[2].expect != 2 {
+		t.Errorf("`[exit N]` annotation not parsed: %#v", lines[2])
+	}
+}
+
+func TestSplitShellWords(t *testing.T) {
+	for _, tc := range []struct {
+		line   string
+		expect []string
+	}{
+		{`a b  c`, []string{"a", "b", "c"}},
+		{`say "hello world" 'it''s' ""`, []string{"say", "hello world", "its", ""}},
+		{`a\ b "q\"uote\n" '$HOME' \$x`, []string{"a b", `q"uote\n`, "$HOME", "$x"}},
+		{`mixed"quo"'ting'`, []string{"mixedquoting"}},
+	} {
+		actual, err := splitShellWords(tc.line)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.line, err)
+			continue
+		}
+		if fmt.Sprintf("%q", actual) != fmt.Sprintf("%q", tc.expect) {
+			t.Errorf("%s: expected %q, got %q", tc.line, tc.expect, actual)
+		}
+	}
+	for _, line := range []string{`"open`, `'open`, `trailing\`} {
+		if _, err := splitShellWords(line); err == nil {
+			t.Errorf("%s: expected an error", line)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+// greet is a tiny program for testing Tester.BuildPackages.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: greet NAMES...")
+		os.Exit(2)
+	}
+	fmt.Printf("hello, %s\n", strings.Join(os.Args[1:], " "))
+}
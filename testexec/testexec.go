@@ -10,13 +10,18 @@ package testexec
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/warpfork/go-testmark"
 )
@@ -38,8 +43,55 @@ type ExecFn func(args []string, stdin io.Reader, stdout, stderr io.Writer) (exit
 // However, one could place any kind of script parser and interpreter within this callback.
 type ScriptFn func(script string, stdin io.Reader, stdout, stderr io.Writer) (exitcode int, oshit error)
 
+// ExecContext describes where and how a command should be run:
+// its working directory, its environment variables, and its stdin.
+// It's given to ContextExecFn and ContextScriptFn callbacks.
+//
+// Callbacks should not change the process's own working directory or environment to apply it,
+// because tests may be running concurrently.
+type ExecContext struct {
+	// Dir is the working directory for the command.  (It's the test case's temp directory.)
+	Dir string
+
+	// Env is the environment for the command, as "KEY=value" strings, in the style of `os.Environ()`.
+	// If a key appears more than once, the last one wins.
+	Env []string
+
+	// Stdin is the input stream for the command.  It's never nil (but it may be empty).
+	Stdin io.Reader
+
+	// Context is done when the command should be stopped: because it timed out, or because the test case is over.
+	// Callbacks should stop the command (and anything it started) when that happens.
+	// It's never nil when given by a Tester (but may be nil if you construct an ExecContext yourself).
+	Context context.Context
+
+	killed   *int32 // Set (atomically) by ContextExecFn_Exec if it killed a command because Context was done.  May be nil.
+	parallel bool   // True if the test case is running in parallel with others (see Tester.Parallel).
+}
+
+// noteKilled records that a command was killed because the Context was done.
+func (ctx ExecContext) noteKilled() {
+	if ctx.killed != nil {
+		atomic.StoreInt32(ctx.killed, 1)
+	}
+}
+
+// ContextExecFn is like ExecFn, but receives an ExecContext describing where to run the command,
+// rather than relying on the process's working directory.
+// If a Tester has a ContextExecFn, it's used instead of the ExecFn.
+type ContextExecFn func(ctx ExecContext, args []string, stdout, stderr io.Writer) (exitcode int, oshit error)
+
+// ContextScriptFn is like ScriptFn, but receives an ExecContext describing where to run the script,
+// rather than relying on the process's working directory.
+// If a Tester has a ContextScriptFn, it's used instead of the ScriptFn.
+type ContextScriptFn func(ctx ExecContext, script string, stdout, stderr io.Writer) (exitcode int, oshit error)
+
 // FilterFn is the outline for a callback that can be used to normalize some parts of output strings.
 // A common example of this is to strip timestamps back out of log messages for a program that emits logs with timestamps.
+//
+// The FilterFn is called on each line of the output (without its linebreak),
+// before the output is checked against the expected hunk, or used to regenerate it.
+// Rules from "filters" hunks in the document are applied after the FilterFn.
 type FilterFn func(line string) (replacement string)
 
 // AssertFn can be used in Tester to specify a better test assertion function.
@@ -47,16 +99,28 @@ type FilterFn func(line string) (replacement string)
 //
 //		func(...) { quicktest.Assert(t, actual, quicktest.CmpEquals(), expect) }
 //
-// The default behavior, if a Tester object doesn't get an AssertFn, is to fall back to a very basic call to `t.Errorf`.
+// The default behavior, if a Tester object doesn't get an AssertFn, is to report a line-based unified diff with `t.Errorf`
+// (see `testmark.UnifiedDiff`).
+// When checking an output hunk, the default also labels the expected side of the diff with the hunk's location (filename and line).
 type AssertFn func(t *testing.T, actual, expect string)
 
+// AssertHunkFn is like AssertFn, but receives the whole expected hunk, rather than just its body.
+// This lets the assertion make choices based on the hunk's InfoString
+// (for example, comparing JSON structurally, if the hunk is tagged as JSON).
+//
+// The `compare.AssertHunk` function in go-testmark's compare package is an AssertHunkFn.
+//
+// If a Tester has an AssertHunkFn, it's used for checking the "output", "stdout", and "stderr" hunks,
+// instead of the AssertFn.  (The AssertFn is still used for the exitcode.)
+type AssertHunkFn func(t *testing.T, actual string, expect testmark.Hunk)
+
 // Tester is a configuration-gathering structure.
 // Each of the `Test*` methods upon it will use these callbacks to define their behavior.
 //
 // All of the fields can be nil, which will result in default behaviors.
-// (A nil ExecFn will result in an OS exec being used (specifically: `ExecFn_Exec`);
+// (A nil ExecFn and ContextExecFn will result in an OS exec being used (specifically: `ContextExecFn_Exec`);
 // a nil FilterFn means no filtering will occur;
-// a nil AssertFn means a very basic check using t.Errorf will be used.)
+// a nil AssertFn means a basic check reporting a unified diff with t.Errorf will be used.)
 //
 // The 'Patches' accumulator will be used to gather new fixture data if `testmark.Regen` is true.
 // (If the pointer is nil, a warning will be logged.)
@@ -66,24 +130,124 @@ type AssertFn func(t *testing.T, actual, expect string)
 type Tester struct {
 	ExecFn
 	ScriptFn
+	ContextExecFn
+	ContextScriptFn
 	FilterFn
 	AssertFn
+	AssertHunkFn
 
 	Patches *testmark.PatchAccumulator
 
-	reportUse     func(string)         // Used to wire with suite, if you use NewSuiteTester.
-	reportUnrecog func(string, string) // Used to wire with suite, if you use NewSuiteTester.
+	// Parallel causes each test case (including each "then-" child) to call `t.Parallel`,
+	// so that cases, and sibling "then-" branches, can run concurrently.
+	//
+	// Parallel can't be combined with an ExecFn or ScriptFn (as opposed to a ContextExecFn or ContextScriptFn),
+	// because those expect to be run with the process's working directory changed to the test case's temp directory.
+	//
+	// Mind that parallel subtests only run after the function that started them returns.
+	// If you're handling Patches manually, write them from a `t.Cleanup` (the suite package does this for you).
+	Parallel bool
+
+	// DefaultTimeout limits how long the commands of each test case may run, if the case has no "timeout" hunk.
+	// Zero means no limit.
+	// Timeouts only work with callbacks that heed ExecContext.Context (like the defaults do); not with an ExecFn or ScriptFn.
+	DefaultTimeout time.Duration
+
+	// Hermetic, if true, runs commands in a controlled environment, so that results don't depend on the machine running the tests.
+	// The environment starts out empty, except for the variables named in HermeticEnv;
+	// HOME and TMPDIR are fresh directories for each test case (they're copied to "then-" children, like the working directory);
+	// LC_ALL is "C", TZ is "UTC", SOURCE_DATE_EPOCH is fixed (see HermeticSourceDateEpoch),
+	// and PATH is HermeticPath (or DefaultHermeticPath).
+	// Any "env" hunks are applied on top of this.
+	Hermetic bool
+
+	// HermeticEnv lists the names of environment variables to pass through from the host in hermetic mode.
+	HermeticEnv []string
+
+	// HermeticPath is the PATH in hermetic mode.  If empty, DefaultHermeticPath is used.
+	HermeticPath string
+
+	// BuildPackages maps Go package import paths to command names.
+	// Each package is built (with `go build`, from the current directory's module, without network access)
+	// into a binary with that name, and the directories of the binaries are put at the front of the PATH of every test case --
+	// so both scripts and sequences can run them.
+	// Each package is built only once per test binary; a build failure fails every test case that needs it.
+	// Relative paths (like "./cmd/mytool") work, too, and are relative to the package being tested.
+	BuildPackages map[string]string
+
+	// Cover collects Go coverage data from the commands that tests run.
+	// BuildPackages are built with `-cover`, and each test case's commands get a GOCOVERDIR of their own.
+	// When each test case finishes, its coverage data is merged into the directory that `go test -cover` gives the test binary
+	// (so it shows up in `go test -cover` reports, and in `-coverprofile`), and into CoverProfile, if that's set.
+	// Cover requires Go 1.20 or later.
+	Cover bool
+
+	// CoverProfile, if set along with Cover, is a text coverage profile (like `go test -coverprofile` writes)
+	// to write the coverage data of the commands to.
+	// It's rewritten, with all the data gathered so far, as each top-level test finishes.
+	CoverProfile string
+
+	// ShellQuoting makes the lines of "sequence" hunks get split into args the way a POSIX shell would split words:
+	// single quotes, double quotes, and backslash escapes are understood (but nothing is expanded).
+	// Otherwise, lines are simply split on whitespace.
+	ShellQuoting bool
+
+	// Wildcards enables matching syntax in the expected "output", "stdout", and "stderr" hunks:
+	// `[..]` within a line matches any text, `{{re:PATTERN}}` within a line matches a regular expression,
+	// and a line that's just `...` matches any number of lines.
+	// In regen mode, the wildcards that still match are kept, and only the lines that really differ are replaced.
+	Wildcards bool
+
+	// ScriptFns are ContextScriptFns for "script" hunks, by the info string of their code fence (e.g. "ruby" for "```ruby").
+	// They're in addition to the built-in ones for "bash", "sh", "python", and "go" (and can replace those, too).
+	// Info strings are matched case-insensitively, and only the first word counts.
+	//
+	// A script with no info string is run with the ContextScriptFn (or ScriptFn).
+	// If the ContextScriptFn (or ScriptFn) is set, scripts marked "bash" or "sh" are run with it, too,
+	// since those info strings are often there just for syntax highlighting --
+	// unless ScriptFns has an entry for them.
+	ScriptFns map[string]ContextScriptFn
+
+	// StrictExpectFS makes it an error for the commands to create or change any file that isn't listed in the "expect-fs" hunks
+	// (in test cases that have any "expect-fs" hunks).
+	StrictExpectFS bool
+
+	// Placeholders are extra values to rewrite into stable names in captured output, in addition to the built-in
+	// "$WORK" (the test case's temp directory).
+	// For example, `{"REPO": "/src/myrepo"}` turns any occurrence of "/src/myrepo" in the output into "$REPO".
+	// (Values are only replaced where they aren't followed by more of a file name; "/src/myrepo2" stays as it is.)
+	Placeholders map[string]string
+
+	// HomePlaceholder, if true, also rewrites the user's home directory into "$HOME" in captured output.
+	// (In hermetic mode, the fresh home directory is always rewritten into "$HOME", whether this is set or not.)
+	HomePlaceholder bool
+
+	// Filename is optional, and only used for labeling the location of expected hunks in failure messages.
+	// If the NewSuiteTester constructor is used, it's filled in automatically.
+	Filename string
+
+	placeholders    []placeholder        // Placeholders for the current test case, including $WORK.
+	envRules        []envRule            // Rules from "env" hunks, including those inherited from parents.
+	timeout         *time.Duration       // From the nearest "timeout" hunk, if any (in this test or its parents).
+	cwd             string               // Body of the nearest "cwd" hunk, if any (in this test or its parents).
+	filters         []filterRule         // Rules from "filters" hunks, including those inherited from parents.
+	defaultAssert   bool                 // True if init filled in the default AssertFn, meaning we may use the fancier default for hunks.
+	defaultScriptFn bool                 // True if init filled in the default ContextScriptFn, meaning scripts marked "bash" or "sh" needn't go to it.
+	reportUse       func(string)         // Used to wire with suite, if you use NewSuiteTester.
+	reportUnrecog   func(string, string) // Used to wire with suite, if you use NewSuiteTester.
 }
 
 func (tcfg *Tester) init() {
-	if tcfg.ExecFn == nil {
-		tcfg.ExecFn = ExecFn_Exec
+	if tcfg.ContextExecFn == nil && tcfg.ExecFn == nil {
+		tcfg.ContextExecFn = ContextExecFn_Exec
 	}
-	if tcfg.ScriptFn == nil {
-		tcfg.ScriptFn = ScriptFn_ExecBash
+	if tcfg.ContextScriptFn == nil && tcfg.ScriptFn == nil {
+		tcfg.ContextScriptFn = ContextScriptFn_ExecBash
+		tcfg.defaultScriptFn = true
 	}
 	if tcfg.AssertFn == nil {
 		tcfg.AssertFn = defaultAssertFn
+		tcfg.defaultAssert = true
 	}
 	if tcfg.reportUse == nil {
 		tcfg.reportUse = func(string) {}
@@ -103,9 +267,11 @@ func (tcfg *Tester) init() {
 // (The ExecFn can be as simple or as complex as you want, of course -- but none of these features are provided for you.)
 //
 // The parser that splits up each line of the sequences into the args string slice is primitive.  It's simply whitespace splitting.
-// If you need to test the handling of arguments that involve whitespace, there are two options:
+// If you need to test the handling of arguments that involve whitespace, there are three options:
 // One, you may want to use TestScript instead (this will let you parse it yourself, and or just hand off to a shell of some kind which means you can use the shell's quoting rules);
-// or, Two, you can change your sequence hunk name to `sequences.jsonl`, and put a json list on each line, which will be parsed and that list becomes the args.
+// or, Two, you can change your sequence hunk name to `sequences.jsonl`, and put a json list on each line, which will be parsed and that list becomes the args;
+// or, Three, you can set Tester.ShellQuoting, which makes the parser understand quotes and backslashes the way a POSIX shell would
+// (but without any of the expansions: no variables, no globs, etc).
 //
 // Each DirEnt can also contain several other named entries which will be treated specially.
 // "output" -- if present, will cause the commands to be given a unified stdout and stderr buffer, and it will be checked against this data when done.
@@ -113,6 +279,27 @@ func (tcfg *Tester) init() {
 // "stderr" -- ditto "stdout", but for (you guessed it) stderr.
 // "exitcode" -- if present, should contains a base-10 number for the expected exit code.  If not present, an exitcode of 0 will be expected.
 //
+// Before output is checked, the path of the temp directory is replaced by "$WORK"
+// (as well as the home directory by "$HOME", if Tester.HomePlaceholder is set, and any custom Tester.Placeholders).
+// The same names can be used in the sequence, and are expanded.
+//
+// "timeout" -- if present, contains a duration (like "10s"); if the commands take longer than that, they're killed, and the test fails.
+// This is inherited by "then-" children, which can override it.  (See also Tester.DefaultTimeout.)
+//
+// "env" -- if present, contains lines of `KEY=value` to set environment variables for the commands, or `-KEY` to unset one.
+// "cwd" -- if present, contains a path (relative to the temp directory) to use as the working directory for the commands.
+// Env and cwd are inherited by "then-" children, which can override them.
+//
+// "filters" -- if present, contains lines of `regexp => replacement` rules, which are applied to each line of output before it's checked.
+// Filters are inherited by "then-" children (which may add more of their own).
+//
+// "expect-fs/*" -- if present, the files in the temp directory are checked after the commands run: "expect-fs/foo.bar" is compared with "foo.bar".
+// Expected files that don't exist fail the test.  With Tester.StrictExpectFS, so do files the commands created or changed that aren't expected.
+// In regen mode, the hunks are patched, and hunks are added for the other files that the commands created or changed.
+// (Placeholders and filters apply to the file contents, just like to the output.)
+//
+// If Tester.Wildcards is set, the "output", "stdout", and "stderr" hunks may use wildcards (see the Wildcards field).
+//
 // Not every data DirEnt has to contain any of "output", "stdout", "stderr", or "exitcode".
 // Containing none of them means the commands in the sequence will all be run,
 // and the exitcode is expected to be zero from each,
@@ -121,9 +308,13 @@ func (tcfg *Tester) init() {
 //
 // Additionally, the commands can be run within a temp directory, with some files pre-populated,
 // by use of data hunks under the "fs/" name.  So, "fs/foo.bar" will result in a file named "foo.bar" in the temp directory.
-// The temp directory is applied by using `os.Chdir`, and so is not safe for use with concurrent tests.
-// There are no special faculties for making empty directories, symlinks, setting file properties, etc;
-// if you need to do anything fancy, a setup script may be a good direction to pursue.
+// The commands are run with the temp directory as their working directory, and it's exported to them as the "WORK" env var.
+// (If an ExecFn or ScriptFn is used, rather than a ContextExecFn or ContextScriptFn,
+// the temp directory is applied by using `os.Chdir` instead, and so is not safe for use with concurrent tests.)
+// The info string of an "fs/*" hunk can give it attributes: `mode=0755` sets the file's mode,
+// `symlink=target` makes a symlink (with an empty body) instead of a file, and `dir` makes an empty directory.
+// (Other words in the info string, like a language name, are ignored.)
+// If you need to do anything fancier, a setup script may be a good direction to pursue.
 //
 // If you wish to run some commands, and gather and test (or ignore!) their output as one block,
 // then run additional commands in a subtest, you can use another special path name
@@ -132,9 +323,16 @@ func (tcfg *Tester) init() {
 // which will have a name that is the rest of the path name once the "then-" prefix has been stripped.
 // Inside that DirEnt, all the same rules apply again (we'll look for a "sequence" hunk, etc).
 //
-// Note that there are no faculties to expect a specific exitcode from a specific line of the sequence,
-// nor are their faculties to extract outputs from one specific line of the sequence.
-// If you are wanting to do these things, you can use the "then-" feature.
+// Normally, the sequence stops at the first command that exits non-zero, and that's the exitcode that's checked.
+// A line of the sequence can instead be annotated with the exit code it's expected to have:
+// `! cmd args` expects any non-zero exit code, and `[exit 2] cmd args` expects exactly 2.
+// An "exitcodes" hunk, if present, contains the expected exit code of each command, one per line
+// (and is an expectation for each command that doesn't have an annotation).
+// Commands that exit as expected don't stop the sequence, so a sequence can show a failing step followed by recovery.
+// A command with an explicit expectation that isn't met stops the sequence, and fails the test.
+//
+// Note that there are no faculties to extract outputs from one specific line of the sequence.
+// If you are wanting to do that, you can use the "then-" feature.
 // (This is probably a nudge towards writing better documentation anyway:
 // if you have a whole series of commands and it's a very specific one that should stand out,
 // you should probably give it separate data blocks for sheer human readability anyway.)
@@ -148,6 +346,7 @@ func (tcfg *Tester) init() {
 // then instead of making any assertions, this function will accumulate patches
 // in the `Tester.Patches` slice.
 // Regen mode will only update hunks that already exist; it won't add them.
+// (Likewise, it updates the exit code annotations in a sequence that already has them, but won't add them to other lines.)
 // As an edge case, note that if that an exitcode hunk is absent, but a nonzero exitcode is encountered,
 // the test will still be failed, even though in patch regen mode most assertions are usually skipped.
 func (tcfg Tester) TestSequence(t *testing.T, data *testmark.DirEnt) {
@@ -155,6 +354,14 @@ func (tcfg Tester) TestSequence(t *testing.T, data *testmark.DirEnt) {
 	tcfg.test(t, data, true, false, "")
 }
 
+// TestScript runs a test based on a "script" instruction -- a hunk called "script" is handed to a ScriptFn, all in one piece.
+// Other than that, all the same rules as TestSequence apply (to "fs/*", "output", "then-*", etc).
+//
+// The ScriptFn is chosen by the info string of the script hunk's code fence:
+// "bash", "sh", "python", and "go" are built in, and others can be added with Tester.ScriptFns.
+// A script hunk with no info string is run with the Tester's ContextScriptFn (or ScriptFn), which defaults to bash;
+// and so are "bash" and "sh" scripts, if the ContextScriptFn (or ScriptFn) was set (see Tester.ScriptFns for the details).
+// An info string with no ScriptFn for it fails the test.
 func (tcfg Tester) TestScript(t *testing.T, data *testmark.DirEnt) {
 	t.Helper()
 	tcfg.test(t, data, false, true, "")
@@ -168,8 +375,22 @@ func (tcfg Tester) Test(t *testing.T, data *testmark.DirEnt) {
 func (tcfg Tester) test(t *testing.T, data *testmark.DirEnt, allowExec, allowScript bool, parentTmpdir string) {
 	t.Helper()
 	tcfg.init()
+	if tcfg.Cover && tcfg.CoverProfile != "" && parentTmpdir == "" {
+		// This is a top-level test; its cleanup runs after all its children (and their cleanups) are done.
+		t.Cleanup(func() {
+			if err := writeCoverProfile(tcfg.CoverProfile); err != nil {
+				t.Errorf("%s", err)
+			}
+		})
+	}
 
 	sequenceHunk, sequenceMode := data.Children["sequence"]
+	if jsonlHunk, exists := data.Children["sequences.jsonl"]; exists {
+		if sequenceMode {
+			t.Fatalf("dir %q contained both a 'sequence' and a 'sequences.jsonl' hunk, which is nonsensical", data.Path)
+		}
+		sequenceHunk, sequenceMode = jsonlHunk, true
+	}
 	scriptHunk, scriptMode := data.Children["script"]
 	if !sequenceMode && !scriptMode {
 		t.Fatalf("dir %q does not contain a 'script' or 'sequence' hunk", data.Path)
@@ -189,6 +410,14 @@ func (tcfg Tester) test(t *testing.T, data *testmark.DirEnt, allowExec, allowScr
 			"nothing to do if requested to regenerate test fixtures but have nowhere to put data",
 		)
 	}
+	// Callbacks without an ExecContext need the process's cwd to be changed for them.
+	needsChdir := (sequenceMode && tcfg.ContextExecFn == nil) || (scriptMode && tcfg.ContextScriptFn == nil)
+	if tcfg.Parallel {
+		if needsChdir {
+			t.Fatalf("Tester.Parallel can't be used with an ExecFn or ScriptFn, because they need os.Chdir; use a ContextExecFn or ContextScriptFn instead")
+		}
+		t.Parallel()
+	}
 
 	// Create a tempdir, and fill it with any files.
 	// (This used to be conditional on if this test, or any parents, had use of a 'fs/*' hunk...
@@ -196,42 +425,99 @@ func (tcfg Tester) test(t *testing.T, data *testmark.DirEnt, allowExec, allowScr
 	//   a test script to start of with commands that initialize a filesystem, and
 	//    forcing such tests to start with a dummy 'fs/this-is-not-relevant' hunk seemed unfortunate.
 	//  I've left the flow as written earlier, in case we want to make a flag to *dis*able this again.)
-	var dir string
+	// In hermetic mode, the tempdir also holds a fresh home dir and temp dir, and the working directory is beside them;
+	// otherwise, the tempdir is the working directory.
+	var caseDir, dir, homeDir, tmpDir string
 	useTmpdirs := true // This was previously conditional: `fsEnt, exists := data.Children["fs"]; exists || parentTmpdir != ""`
 	if useTmpdirs {
-		// Create and get into a tempdir.
+		// Create a tempdir.
+		// It's removed in cleanup, rather than on return, because "then-" children copy it, and they may run in parallel (i.e., later).
 		var err error
-		dir, err = ioutil.TempDir("", "testmarkexec")
+		caseDir, err = ioutil.TempDir("", "testmarkexec")
 		if err != nil {
 			t.Fatalf("test aborted: could not create tempdir: %s", err)
 		}
-		defer os.RemoveAll(dir)
-		retreat, err := os.Getwd()
-		if err != nil {
-			t.Fatalf("test aborted: could not find cwd: %s", err)
-		}
-		defer os.Chdir(retreat)
-		if err := os.Chdir(dir); err != nil {
-			t.Fatalf("test aborted: could not chdir to tempdir: %s", err)
-		}
+		t.Cleanup(func() { os.RemoveAll(caseDir) })
 
 		// If there was a parent tempdir: copy those files first.
 		if parentTmpdir != "" {
-			if err := copyFiles(parentTmpdir, dir); err != nil {
+			if err := copyFiles(parentTmpdir, caseDir); err != nil {
 				t.Fatalf("test aborted: could not populate files to tempdir: %s", err)
 			}
 		}
 
+		dir = caseDir
+		if tcfg.Hermetic {
+			dir, homeDir, tmpDir = hermeticDirs(caseDir)
+			for _, d := range []string{dir, homeDir, tmpDir} {
+				if err := os.MkdirAll(d, 0755); err != nil {
+					t.Fatalf("test aborted: could not populate files to tempdir: %s", err)
+				}
+			}
+		}
+
 		// Create any new files.
 		fsEnt := data.Children["fs"]
 		if fsEnt != nil {
-			if err := tcfg.createFiles(fsEnt, "."); err != nil {
+			if err := tcfg.createFiles(fsEnt, dir); err != nil {
 				t.Fatalf("test aborted: could not populate files to tempdir: %s", err)
 			}
 		}
 	}
 
+	tcfg.placeholders = tcfg.placeholdersFor(dir, homeDir, tmpDir)
+
+	// Gather any filter rules.  These accumulate: a "then-" child also gets its parents' rules.
+	if ent := data.Children["filters"]; ent != nil && ent.Hunk != nil {
+		tcfg.reportUse(ent.Path)
+		rules, err := parseFilters(string(ent.Hunk.Body))
+		if err != nil {
+			t.Fatalf("invalid filters hunk %q: %s", ent.Path, err)
+		}
+		tcfg.filters = append(append([]filterRule{}, tcfg.filters...), rules...)
+	}
+
+	// Gather any env rules, and the cwd.  These are inherited by "then-" children, which can override them.
+	if ent := data.Children["env"]; ent != nil && ent.Hunk != nil {
+		tcfg.reportUse(ent.Path)
+		rules, err := tcfg.parseEnv(string(ent.Hunk.Body))
+		if err != nil {
+			t.Fatalf("invalid env hunk %q: %s", ent.Path, err)
+		}
+		tcfg.envRules = append(append([]envRule{}, tcfg.envRules...), rules...)
+	}
+	if ent := data.Children["cwd"]; ent != nil && ent.Hunk != nil {
+		tcfg.reportUse(ent.Path)
+		tcfg.cwd = string(ent.Hunk.Body)
+	}
+	cwd := dir
+	if tcfg.cwd != "" {
+		var err error
+		cwd, err = resolveCwd(dir, tcfg.cwd)
+		if err != nil {
+			t.Fatalf("invalid cwd hunk: %s", err)
+		}
+		if fi, err := os.Stat(cwd); err != nil || !fi.IsDir() {
+			t.Fatalf("test aborted: cwd %q is not a directory in the test's filesystem", strings.TrimSpace(tcfg.cwd))
+		}
+	}
+
+	// Figure out the timeout.  Like env, this is inherited, and can be overridden.
+	if ent := data.Children["timeout"]; ent != nil && ent.Hunk != nil {
+		tcfg.reportUse(ent.Path)
+		d, err := time.ParseDuration(strings.TrimSpace(string(ent.Hunk.Body)))
+		if err != nil {
+			t.Fatalf("invalid timeout hunk %q: %s", ent.Path, err)
+		}
+		tcfg.timeout = &d
+	}
+	timeout := tcfg.DefaultTimeout
+	if tcfg.timeout != nil {
+		timeout = *tcfg.timeout
+	}
+
 	// Prepare output buffers.
+	// Output is collected even if it won't be checked, so that it can be reported if there's a timeout.
 	var stdout, stderr io.Writer
 	if _, exists := data.Children["output"]; exists {
 		tcfg.reportUse(data.Children["output"].Path)
@@ -252,6 +538,12 @@ func (tcfg Tester) test(t *testing.T, data *testmark.DirEnt, allowExec, allowScr
 		tcfg.reportUse(data.Children["stderr"].Path)
 		stderr = &bytes.Buffer{}
 	}
+	if stdout == nil {
+		stdout = &bytes.Buffer{}
+	}
+	if stderr == nil {
+		stderr = &bytes.Buffer{}
+	}
 	var exitcode int
 
 	// Prepare an input buffer, if applicable.
@@ -264,47 +556,123 @@ func (tcfg Tester) test(t *testing.T, data *testmark.DirEnt, allowExec, allowScr
 	}
 
 	// Do the thing.
+	// The tempdir is exported as $WORK, so scripts can refer to it the same way their expected output does.
+	baseEnv := os.Environ()
+	if tcfg.Hermetic {
+		baseEnv = tcfg.hermeticEnv(homeDir, tmpDir)
+	}
+	// The context is cancelled when this test case is done (or when it times out, which kills any command still running).
+	runCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if timeout > 0 {
+		runCtx, cancel = context.WithTimeout(runCtx, timeout)
+		defer cancel()
+	}
+	env := applyEnv(append(baseEnv, "WORK="+dir), tcfg.envRules)
+	if commandsBinDir != "" {
+		env = prependPath(env, commandsBinDir)
+	}
+	if tcfg.Cover {
+		coverDir, err := ioutil.TempDir("", "testexec-gocoverdir-")
+		if err != nil {
+			t.Fatalf("test aborted: could not create tempdir: %s", err)
+		}
+		t.Cleanup(func() {
+			defer os.RemoveAll(coverDir)
+			if err := tcfg.collectCoverage(coverDir); err != nil {
+				t.Errorf("could not collect coverage data: %s", err)
+			}
+		})
+		env = applyEnv(env, []envRule{{key: "GOCOVERDIR", value: coverDir}})
+	}
+	if len(tcfg.BuildPackages) > 0 {
+		var buildFlags []string
+		if tcfg.Cover {
+			buildFlags = append(buildFlags, "-cover")
+		}
+		binDirs, err := tcfg.buildPackages(buildFlags...)
+		if err != nil {
+			t.Fatalf("test aborted: %s", err)
+		}
+		for _, binDir := range binDirs {
+			env = prependPath(env, binDir)
+		}
+	}
+	var killed int32
+	ctx := ExecContext{
+		Dir:      cwd,
+		Env:      env,
+		Stdin:    stdin,
+		Context:  runCtx,
+		killed:   &killed,
+		parallel: tcfg.Parallel,
+	}
+	if needsChdir {
+		retreat, err := os.Getwd()
+		if err != nil {
+			t.Fatalf("test aborted: could not find cwd: %s", err)
+		}
+		defer os.Chdir(retreat)
+		if err := os.Chdir(ctx.Dir); err != nil {
+			t.Fatalf("test aborted: could not chdir to tempdir: %s", err)
+		}
+		defer swapProcessEnv(ctx.Env)()
+	}
+	// If there are files to check afterwards, note what's there now, so the files the commands produce can be told apart.
+	var filesBefore fsSnapshot
+	if data.Children["expect-fs"] != nil {
+		var err error
+		if filesBefore, err = snapshotFiles(dir); err != nil {
+			t.Fatalf("test aborted: could not read the files in the working directory: %s", err)
+		}
+	}
+	var seqResults []sequenceResult
 	switch {
 	case sequenceMode:
 		tcfg.reportUse(sequenceHunk.Path)
-		exitcode = tcfg.doSequence(t, sequenceHunk.Hunk, stdin, stdout, stderr)
+		var expectCodes []int
+		if ent := data.Children["exitcodes"]; ent != nil && ent.Hunk != nil {
+			tcfg.reportUse(ent.Path)
+			var err error
+			expectCodes, err = parseExitcodes(string(ent.Hunk.Body))
+			if err != nil {
+				t.Fatalf("invalid exitcodes hunk %q: %s", ent.Path, err)
+			}
+		}
+		exitcode, seqResults = tcfg.doSequence(t, sequenceHunk.Hunk, expectCodes, ctx, stdout, stderr)
 	case scriptMode:
 		tcfg.reportUse(scriptHunk.Path)
-		exitcode = tcfg.doScript(t, scriptHunk.Hunk, stdin, stdout, stderr)
+		exitcode = tcfg.doScript(t, scriptHunk.Hunk, ctx, stdout, stderr)
+	}
+	// It's a timeout if a command was killed for it.
+	// (Custom callbacks can't say whether they killed anything, so for them, it's a timeout if they ran past the deadline.
+	// A command that finished just before the deadline isn't a timeout, even if the deadline has passed by now.)
+	finished := time.Now()
+	deadline, hasDeadline := runCtx.Deadline()
+	if atomic.LoadInt32(&killed) != 0 || (hasDeadline && !finished.Before(deadline) && runCtx.Err() == context.DeadlineExceeded) {
+		t.Fatalf("test aborted: timed out after %s (the whole process group was killed)\n%s", timeout, describePartialOutput(stdout, stderr))
 	}
 
 	// Okay, comparisons time.
 	// Or, regen time!
 	if ent, exists := data.Children["output"]; exists {
 		// stdout buffer should be prepared to be both stdout and stderr earlier before execution.
-		bs := stdout.(*bytes.Buffer).Bytes()
-		if *testmark.Regen {
-			tcfg.Patches.AppendPatchIfBodyDiffers(*ent.Hunk, bs)
-		} else {
-			t.Run("check-combined-output", func(t *testing.T) {
-				tcfg.AssertFn(t, string(bs), string(ent.Hunk.Body))
-			})
-		}
+		tcfg.checkOutput(t, "check-combined-output", stdout.(*bytes.Buffer).Bytes(), ent)
 	}
 	if ent, exists := data.Children["stdout"]; exists {
-		bs := stdout.(*bytes.Buffer).Bytes()
-		if *testmark.Regen {
-			tcfg.Patches.AppendPatchIfBodyDiffers(*ent.Hunk, bs)
-		} else {
-			t.Run("check-stdout", func(t *testing.T) {
-				tcfg.AssertFn(t, string(bs), string(ent.Hunk.Body))
-			})
-		}
+		tcfg.checkOutput(t, "check-stdout", stdout.(*bytes.Buffer).Bytes(), ent)
 	}
 	if ent, exists := data.Children["stderr"]; exists {
-		bs := stderr.(*bytes.Buffer).Bytes()
-		if *testmark.Regen {
-			tcfg.Patches.AppendPatchIfBodyDiffers(*ent.Hunk, bs)
-		} else {
-			t.Run("check-stderr", func(t *testing.T) {
-				tcfg.AssertFn(t, string(bs), string(ent.Hunk.Body))
-			})
-		}
+		tcfg.checkOutput(t, "check-stderr", stderr.(*bytes.Buffer).Bytes(), ent)
+	}
+	if sequenceMode {
+		tcfg.checkSequenceExitcodes(t, sequenceHunk, data.Children["exitcodes"], seqResults)
+	} else if ent, exists := data.Children["exitcodes"]; exists {
+		t.Errorf("testexec entry %q has an 'exitcodes' hunk, but that's only meaningful with a 'sequence'", data.Name)
+		tcfg.reportUse(ent.Path)
+	}
+	if ent := data.Children["expect-fs"]; ent != nil {
+		tcfg.checkFiles(t, ent, dir, filesBefore)
 	}
 	t.Run("check-exitcode", func(t *testing.T) {
 		if ent, exists := data.Children["exitcode"]; exists {
@@ -319,7 +687,91 @@ func (tcfg Tester) test(t *testing.T, data *testmark.DirEnt, allowExec, allowScr
 		}
 	})
 
-	tcfg.recurse(t, data, allowExec, allowScript, dir)
+	tcfg.recurse(t, data, allowExec, allowScript, caseDir)
+}
+
+// checkOutput either asserts that the output matches the expected hunk, or (in regen mode) patches the hunk.
+// Placeholders are substituted and filters are applied to the output first, in either case.
+// If wildcards are enabled and the hunk uses them, the output is first merged with the expected body,
+// so that wildcards which still match are kept (in regen), and not reported as differences (in assertions).
+func (tcfg Tester) checkOutput(t *testing.T, checkName string, bs []byte, ent *testmark.DirEnt) {
+	t.Helper()
+	bs, err := tcfg.prepareActual(bs, ent.Hunk.Body)
+	if err != nil {
+		t.Errorf("hunk %q: %s", ent.Path, err)
+		return
+	}
+	if *testmark.Regen {
+		tcfg.Patches.AppendPatchIfBodyDiffers(*ent.Hunk, bs)
+	} else {
+		t.Run(checkName, func(t *testing.T) {
+			tcfg.assertHunk(t, string(bs), ent)
+		})
+	}
+}
+
+// prepareActual substitutes placeholders and applies filters to some actual output,
+// and merges it with the expected body, if wildcards are enabled and the expected body uses them.
+func (tcfg Tester) prepareActual(bs []byte, expect []byte) ([]byte, error) {
+	bs = tcfg.filterOutput(tcfg.substitutePlaceholders(bs))
+	if tcfg.Wildcards && hasWildcards(expect) {
+		merged, err := mergeWildcards(string(expect), string(bs))
+		if err != nil {
+			return nil, err
+		}
+		bs = []byte(merged)
+	}
+	return bs, nil
+}
+
+// checkSequenceExitcodes checks the exit codes of each command of a sequence against their annotations, and the "exitcodes" hunk (if there is one).
+// In regen mode, it patches the annotations and the "exitcodes" hunk instead.
+func (tcfg Tester) checkSequenceExitcodes(t *testing.T, sequenceEnt, exitcodesEnt *testmark.DirEnt, results []sequenceResult) {
+	t.Helper()
+	if *testmark.Regen {
+		body := string(sequenceEnt.Hunk.Body)
+		tcfg.Patches.AppendPatchIfBodyDiffers(*sequenceEnt.Hunk, []byte(reannotateSequence(body, results)))
+		if exitcodesEnt != nil && exitcodesEnt.Hunk != nil {
+			tcfg.Patches.AppendPatchIfBodyDiffers(*exitcodesEnt.Hunk, []byte(formatExitcodes(results)))
+		}
+		return
+	}
+	t.Run("check-exitcodes", func(t *testing.T) {
+		for _, res := range results {
+			if res.line.annotated && res.mismatch {
+				t.Errorf("line %d of the sequence (%q) expected %s, but exited %d", res.line.lineIdx+1, res.line.rest, res.describeExpectation(), res.exitcode)
+			}
+		}
+		if exitcodesEnt != nil && exitcodesEnt.Hunk != nil {
+			expect, _ := parseExitcodes(string(exitcodesEnt.Hunk.Body)) // Already validated.
+			var sb strings.Builder
+			for _, code := range expect {
+				fmt.Fprintf(&sb, "%d\n", code)
+			}
+			tcfg.AssertFn(t, formatExitcodes(results), sb.String())
+		}
+	})
+}
+
+// describePartialOutput formats whatever output was collected, for reporting after a timeout.
+func describePartialOutput(stdout, stderr io.Writer) string {
+	if stdout == stderr {
+		return fmt.Sprintf("output so far:\n%s", stdout.(*bytes.Buffer).Bytes())
+	}
+	return fmt.Sprintf("stdout so far:\n%s\nstderr so far:\n%s", stdout.(*bytes.Buffer).Bytes(), stderr.(*bytes.Buffer).Bytes())
+}
+
+// assertHunk checks output against an expected hunk, using the AssertHunkFn if there is one, or else the AssertFn.
+func (tcfg Tester) assertHunk(t *testing.T, actual string, expect *testmark.DirEnt) {
+	t.Helper()
+	switch {
+	case tcfg.AssertHunkFn != nil:
+		tcfg.AssertHunkFn(t, actual, *expect.Hunk)
+	case tcfg.defaultAssert && expect.DocHunk != nil:
+		defaultAssertHunk(t, actual, tcfg.Filename, *expect.DocHunk)
+	default:
+		tcfg.AssertFn(t, actual, string(expect.Hunk.Body))
+	}
 }
 
 func (tcfg Tester) recurse(t *testing.T, data *testmark.DirEnt, allowExec bool, allowScript bool, parentTmpDir string) {
@@ -344,54 +796,146 @@ func (tcfg Tester) recurse(t *testing.T, data *testmark.DirEnt, allowExec bool,
 
 // Hash Table of all the "special" nodes used by testexec.
 var leafNodeTable = map[string]struct{}{
-	"exitcode": {},
-	"stderr":   {},
-	"stdout":   {},
-	"output":   {},
-	"input":    {},
-	"sequence": {},
-	"script":   {},
-	"fs":       {},
+	"exitcode":        {},
+	"stderr":          {},
+	"stdout":          {},
+	"output":          {},
+	"input":           {},
+	"sequence":        {},
+	"sequences.jsonl": {},
+	"script":          {},
+	"fs":              {},
+	"expect-fs":       {},
+	"filters":         {},
+	"env":             {},
+	"cwd":             {},
+	"timeout":         {},
+	"exitcodes":       {},
 }
 
-func (tcfg Tester) doSequence(t *testing.T, hunk *testmark.Hunk, stdin io.Reader, stdout, stderr io.Writer) (exitcode int) {
+// doSequence runs each command of the sequence, and returns the exit code of the first command that failed unexpectedly (or zero),
+// as well as a record of each command that was run.
+//
+// Each command is expected to exit zero, unless it has an annotation (see sequenceLine), or an entry in expectCodes (from an "exitcodes" hunk).
+// When a command's exit code isn't what was expected, the sequence stops.
+// (In regen mode, a command with an explicit expectation doesn't stop the sequence, since its expectation will be updated.)
+func (tcfg Tester) doSequence(t *testing.T, hunk *testmark.Hunk, expectCodes []int, ctx ExecContext, stdout, stderr io.Writer) (exitcode int, results []sequenceResult) {
 	t.Helper()
 	// Loop over the lines in the sequence.
-	lines := bytes.Split(hunk.Body, []byte{'\n'})
-	for _, line := range lines {
-		args := strings.Fields(string(line))
+	for _, sl := range parseSequenceLines(string(hunk.Body)) {
+		var args []string
+		var err error
+		switch {
+		case hunk.Name == "sequences.jsonl" || strings.HasSuffix(hunk.Name, "/sequences.jsonl"):
+			err = json.Unmarshal([]byte(sl.rest), &args)
+		case tcfg.ShellQuoting:
+			args, err = splitShellWords(sl.rest)
+		default:
+			args = strings.Fields(sl.rest)
+		}
+		if err != nil {
+			t.Fatalf("invalid line %d in sequence hunk %q: %s", sl.lineIdx+1, hunk.Name, err)
+		}
 		if len(args) < 1 {
-			continue
+			continue // Nothing to run (e.g. a "[]" line in jsonl), so it's not a command, and doesn't get an entry in expectCodes.
+		}
+		for i := range args {
+			args[i] = tcfg.expandPlaceholders(args[i])
 		}
 
-		var err error
-		exitcode, err = tcfg.ExecFn(args, stdin, stdout, stderr)
+		var code int
+		if tcfg.ContextExecFn != nil {
+			code, err = tcfg.ContextExecFn(ctx, args, stdout, stderr)
+		} else {
+			code, err = tcfg.ExecFn(args, ctx.Stdin, stdout, stderr)
+		}
 		if err != nil {
 			t.Fatalf("execution failed: error from ExecFn is %q", err)
 		}
-		if exitcode != 0 {
-			break // TODO: it's probably still an error if that happens before the end?
+
+		res := sequenceResult{line: sl, exitcode: code}
+		switch {
+		case sl.annotated && sl.expectAny:
+			res.explicit, res.mismatch = true, code == 0
+		case sl.annotated:
+			res.explicit, res.expected, res.mismatch = true, sl.expect, code != sl.expect
+		case len(results) < len(expectCodes):
+			n := len(results)
+			res.explicit, res.expected, res.mismatch = true, expectCodes[n], code != expectCodes[n]
+		default:
+			res.mismatch = code != 0
+		}
+		results = append(results, res)
+		if ctx.Context != nil && ctx.Context.Err() != nil {
+			break // Timed out; there's no point in running anything else.
+		}
+		if !res.mismatch {
+			continue
+		}
+		if !res.explicit {
+			exitcode = code
+			break
+		}
+		if !*testmark.Regen {
+			break
 		}
 	}
 	return
 }
 
-func (tcfg Tester) doScript(t *testing.T, hunk *testmark.Hunk, stdin io.Reader, stdout, stderr io.Writer) (exitcode int) {
+func (tcfg Tester) doScript(t *testing.T, hunk *testmark.Hunk, ctx ExecContext, stdout, stderr io.Writer) (exitcode int) {
 	t.Helper()
-	var err error
-	exitcode, err = tcfg.ScriptFn(string(hunk.Body), stdin, stdout, stderr)
+	scriptFn, err := tcfg.scriptFnFor(hunk.InfoString)
+	if err != nil {
+		t.Fatalf("cannot run script hunk %q: %s", hunk.Name, err)
+	}
+	exitcode, err = scriptFn(ctx, string(hunk.Body), stdout, stderr)
 	if err != nil {
 		t.Fatalf("execution failed: error from script is %q", err)
 	}
 	return
 }
 
+// createFile makes the file (or directory, or symlink) described by an "fs/*" hunk, according to the attributes in its info string.
+func createFile(hunk *testmark.Hunk, path string) error {
+	attrs, err := parseFileAttrs(hunk.InfoString)
+	if err != nil {
+		return err
+	}
+	if (attrs.dir || attrs.symlink != "") && len(hunk.Body) > 0 {
+		return fmt.Errorf("a dir or symlink must have an empty body")
+	}
+	switch {
+	case attrs.symlink != "":
+		// Replace whatever a parent test case left here.
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return os.Symlink(attrs.symlink, path)
+	case attrs.dir:
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+	default:
+		if err := ioutil.WriteFile(path, hunk.Body, 0644); err != nil {
+			return err
+		}
+	}
+	if attrs.mode != 0 {
+		// Chmod explicitly, because the umask applies when creating, and the mode isn't changed if the file already existed.
+		return os.Chmod(path, attrs.mode)
+	}
+	return nil
+}
+
 // createFiles makes files and directories matching testmark hunks.
-// It creates them relative to the os cwd plus prefix -- use with care.
+// It creates them at the prefix path (relative to the os cwd, if the prefix isn't absolute) -- use with care.
 func (tcfg Tester) createFiles(dir *testmark.DirEnt, prefix string) error {
 	tcfg.reportUse(dir.Path)
 	if dir.Hunk != nil {
-		return ioutil.WriteFile(prefix, dir.Hunk.Body, 0644)
+		if err := createFile(dir.Hunk, prefix); err != nil {
+			return fmt.Errorf("hunk %q: %w", dir.Path, err)
+		}
 	} else {
 		if err := os.MkdirAll(prefix, 0755); err != nil {
 			return err
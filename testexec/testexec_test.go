@@ -1,15 +1,51 @@
 package testexec_test
 
 import (
+	"bytes"
+	"context"
 	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/warpfork/go-testmark"
+	"github.com/warpfork/go-testmark/compare"
 	"github.com/warpfork/go-testmark/testexec"
 )
 
 var RunFailTest = flag.Bool("run-fail-test", false, "Executes the tests which are expected to fail")
 
+// testCommands are available, via RunMain, as subprocesses on the PATH of every test.  (See also inProcessCommands.)
+var testCommands = testexec.Commands{"testexec-greet": greet}
+
+func TestMain(m *testing.M) {
+	os.Exit(testCommands.RunMain(m))
+}
+
+// greet greets its args, or (with "-f") the contents of a file.
+func greet(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	switch {
+	case len(args) < 2:
+		fmt.Fprintln(stderr, "usage: testexec-greet NAMES... | -f FILE")
+		return 2
+	case args[1] == "-f" && len(args) == 3:
+		bs, err := os.ReadFile(args[2])
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		args = []string{args[0], strings.TrimSpace(string(bs))}
+	}
+	fmt.Fprintf(stdout, "hello, %s\n", strings.Join(args[1:], " "))
+	return 0
+}
+
 func TestSelfExercise(t *testing.T) {
 	filename := "selfexercise.md"
 	doc, err := testmark.ReadFile(filename)
@@ -30,6 +66,29 @@ func TestSelfExercise(t *testing.T) {
 	patches.WriteFileWithPatches(doc, filename)
 }
 
+func TestSelfExerciseParallel(t *testing.T) {
+	filename := "selfexercise.md"
+	doc, err := testmark.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("spec file parse failed?!: %s", err)
+	}
+
+	doc.BuildDirIndex()
+	patches := testmark.PatchAccumulator{}
+	// The cases run in parallel, so they're only done once cleanup time comes.
+	t.Cleanup(func() { patches.WriteFileWithPatches(doc, filename) })
+	for _, dir := range doc.DirEnt.ChildrenList {
+		dir := dir
+		t.Run(dir.Name, func(t *testing.T) {
+			test := testexec.Tester{
+				Patches:  &patches,
+				Parallel: true,
+			}
+			test.TestScript(t, dir)
+		})
+	}
+}
+
 func TestInvalid(t *testing.T) {
 	if !(*RunFailTest) {
 		t.Skipf("%s requires %q flag to execute", t.Name(), "run-fail-test")
@@ -53,6 +112,26 @@ func TestInvalid(t *testing.T) {
 	patches.WriteFileWithPatches(doc, filename)
 }
 
+func TestInvalidStrictExpectFS(t *testing.T) {
+	if !(*RunFailTest) {
+		t.Skipf("%s requires %q flag to execute", t.Name(), "run-fail-test")
+	}
+	filename := "invalidexercise.md"
+	doc, err := testmark.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("spec file parse failed?!: %s", err)
+	}
+
+	doc.BuildDirIndex()
+	patches := testmark.PatchAccumulator{}
+	test := testexec.Tester{
+		Patches:        &patches,
+		StrictExpectFS: true,
+	}
+	test.TestScript(t, doc.DirEnt.Children["expect-fs"])
+	patches.WriteFileWithPatches(doc, filename)
+}
+
 func TestStrict(t *testing.T) {
 	if !(*RunFailTest) {
 		t.Skipf("%s requires %q flag to execute", t.Name(), "run-fail-test")
@@ -75,3 +154,236 @@ func TestStrict(t *testing.T) {
 	}
 	patches.WriteFileWithPatches(doc, filename)
 }
+func TestAssertHunkFn(t *testing.T) {
+	doc, err := testmark.Parse([]byte("" +
+		"[testmark]:# (semantic/script)\n```\necho '{\"b\": [1, 2], \"a\": true}'\n```\n" +
+		"[testmark]:# (semantic/output)\n```json\n{\n\t\"a\": true,\n\t\"b\": [1, 2]\n}\n```\n",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.BuildDirIndex()
+	testexec.Tester{AssertHunkFn: compare.AssertHunk}.TestScript(t, doc.DirEnt.Children["semantic"])
+}
+
+func TestTimeoutKillsProcessGroup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	var buf bytes.Buffer
+	start := time.Now()
+	// The background sleep holds on to stdout, so this would wait for it too, if only the shell were killed.
+	exitcode, err := testexec.ContextExecFn_Exec(
+		testexec.ExecContext{Stdin: bytes.NewReader(nil), Context: ctx},
+		[]string{"sh", "-c", "echo started; sleep 30 & sleep 30"},
+		&buf, &buf,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("command should've been killed after the timeout, but took %s", elapsed)
+	}
+	if exitcode >= 0 {
+		t.Errorf("expected the command to have been killed by a signal, but exitcode was %d", exitcode)
+	}
+	if buf.String() != "started\n" {
+		t.Errorf("expected partial output %q, got %q", "started\n", buf.String())
+	}
+}
+
+// exitFn is a ContextExecFn that understands one command, "exit N", which prints N, and exits with it.
+func exitFn(ctx testexec.ExecContext, args []string, stdout, stderr io.Writer) (int, error) {
+	if args[0] != "exit" || len(args) != 2 {
+		return -1000, fmt.Errorf("unknown command %q", args)
+	}
+	code, err := strconv.Atoi(args[1])
+	fmt.Fprintf(stdout, "%d\n", code)
+	return code, err
+}
+func TestInterpreter(t *testing.T) {
+	filename := "interpexercise.md"
+	doc, err := testmark.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("spec file parse failed?!: %s", err)
+	}
+
+	doc.BuildDirIndex()
+	patches := testmark.PatchAccumulator{}
+	t.Cleanup(func() { patches.WriteFileWithPatches(doc, filename) })
+	for _, dir := range doc.DirEnt.ChildrenList {
+		dir := dir
+		t.Run(dir.Name, func(t *testing.T) {
+			test := testexec.Tester{
+				ContextScriptFn: testexec.ContextScriptFn_Interpret,
+				Patches:         &patches,
+				Parallel:        true,
+			}
+			test.TestScript(t, dir)
+		})
+	}
+}
+
+// A featureCase says how to test one of the top-level cases in featureexercise.md.
+type featureCase struct {
+	tester testexec.Tester
+
+	// setup, if set, is called before the case is run.
+	// It may adjust the Tester, and use t.Cleanup to check things after the case (and its Tester's cleanups) are done.
+	setup func(t *testing.T, tcfg *testexec.Tester)
+}
+
+// featureCases has the Tester for each of the top-level cases in featureexercise.md, by name.
+var featureCases = map[string]featureCase{
+	"wildcards": {tester: testexec.Tester{Wildcards: true}},
+	"filter-fn": {tester: testexec.Tester{
+		FilterFn: func(line string) string { return strings.TrimRight(line, "0123456789") + "N" },
+	}},
+	"placeholders": {tester: testexec.Tester{Placeholders: map[string]string{"WHO": "world"}}},
+	"home-placeholder": {
+		tester: testexec.Tester{HomePlaceholder: true},
+		setup: func(t *testing.T, tcfg *testexec.Tester) {
+			home := os.Getenv("HOME")
+			t.Cleanup(func() { os.Setenv("HOME", home) })
+			os.Setenv("HOME", "/home/testmark-user")
+		},
+	},
+	"script-fn-env": {
+		tester: testexec.Tester{ScriptFn: testexec.ScriptFn_ExecBash},
+		setup: func(t *testing.T, tcfg *testexec.Tester) {
+			t.Cleanup(func() {
+				if _, exists := os.LookupEnv("GREETING"); exists {
+					t.Errorf("env should have been restored after the test")
+				}
+			})
+		},
+	},
+	"hermetic": {
+		tester: testexec.Tester{Hermetic: true, HermeticEnv: []string{"TESTMARK_PASSED"}},
+		setup: func(t *testing.T, tcfg *testexec.Tester) {
+			os.Setenv("TESTMARK_PASSED", "passed")
+			os.Setenv("TESTMARK_BLOCKED", "leaked")
+			t.Cleanup(func() {
+				os.Unsetenv("TESTMARK_PASSED")
+				os.Unsetenv("TESTMARK_BLOCKED")
+			})
+		},
+	},
+	"background": {
+		setup: func(t *testing.T, tcfg *testexec.Tester) {
+			var pid int
+			tcfg.ContextScriptFn = func(ctx testexec.ExecContext, script string, stdout, stderr io.Writer) (int, error) {
+				exitcode, err := testexec.ContextScriptFn_ExecBash(ctx, script, stdout, stderr)
+				bs, _ := os.ReadFile(filepath.Join(ctx.Dir, "pid"))
+				pid, _ = strconv.Atoi(strings.TrimSpace(string(bs)))
+				return exitcode, err
+			}
+			t.Cleanup(func() {
+				if pid == 0 {
+					t.Fatalf("the script didn't record the pid of its background process")
+				}
+				checkProcessGone(t, pid)
+			})
+		},
+	},
+	"exit-annotated":      {tester: testexec.Tester{ContextExecFn: exitFn}},
+	"exit-recorded":       {tester: testexec.Tester{ContextExecFn: exitFn}},
+	"exit-recorded-jsonl": {tester: testexec.Tester{ContextExecFn: exitFn}},
+	"exit-legacy":         {tester: testexec.Tester{ContextExecFn: exitFn}},
+	"jsonl":               {tester: testexec.Tester{}},
+	"quoted":              {tester: testexec.Tester{ShellQuoting: true}},
+	// In-process commands change the process's working directory and environment, so these can't be parallel.
+	"in-process":     {tester: testexec.Tester{ContextExecFn: inProcessCommands.ContextExecFn}},
+	"subprocess":     {tester: testexec.Tester{}},
+	"build-packages": {tester: testexec.Tester{BuildPackages: map[string]string{"./testdata/greet": "greet"}}},
+	"cover": {
+		tester: testexec.Tester{BuildPackages: map[string]string{"./testdata/greet": "greet"}, Cover: true},
+		setup: func(t *testing.T, tcfg *testexec.Tester) {
+			tcfg.CoverProfile = filepath.Join(t.TempDir(), "cover.out")
+			// The profile is written when the top-level test finishes, by the Tester's own cleanup, which runs before this one.
+			t.Cleanup(func() {
+				bs, err := os.ReadFile(tcfg.CoverProfile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(string(bs), "testexec/testdata/greet/main.go") {
+					t.Errorf("expected the profile to cover the greet command, got:\n%s", bs)
+				}
+			})
+		},
+	},
+	"script-sh": {tester: testexec.Tester{}},
+	"script-python": {
+		setup: func(t *testing.T, tcfg *testexec.Tester) {
+			if _, err := exec.LookPath("python3"); err != nil {
+				t.Skip("python3 is not installed")
+			}
+		},
+	},
+	"script-go":       {tester: testexec.Tester{}},
+	"script-custom":   {tester: testexec.Tester{ScriptFns: map[string]testexec.ContextScriptFn{"upper": upperScriptFn}}},
+	"script-explicit": {tester: testexec.Tester{ContextScriptFn: testexec.ContextScriptFn_Interpret}},
+	"expect-fs":       {tester: testexec.Tester{StrictExpectFS: true}},
+	"expect-fs-regen": {
+		setup: func(t *testing.T, tcfg *testexec.Tester) {
+			oldRegen := *testmark.Regen
+			*testmark.Regen = true
+			patches := &testmark.PatchAccumulator{}
+			tcfg.Patches = patches
+			t.Cleanup(func() {
+				*testmark.Regen = oldRegen
+				got := map[string]string{}
+				for _, hunk := range patches.Patches {
+					got[hunk.Name] = string(hunk.Body)
+				}
+				expect := map[string]string{
+					"expect-fs-regen/expect-fs/copy":  "changed\n",
+					"expect-fs-regen/expect-fs/extra": "extra\n",
+				}
+				if fmt.Sprint(got) != fmt.Sprint(expect) {
+					t.Errorf("expected patches %v, got %v", expect, got)
+				}
+			})
+		},
+	},
+}
+
+// inProcessCommands are the commands for the "in-process" case of featureexercise.md.
+var inProcessCommands = testexec.Commands{
+	"testexec-greet": greet,
+	"testexec-inprocess-only": func(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+		fmt.Fprintln(stdout, "only in-process")
+		return 0
+	},
+}
+
+// upperScriptFn is a ContextScriptFn that prints the script in upper case.
+func upperScriptFn(ctx testexec.ExecContext, script string, stdout, stderr io.Writer) (int, error) {
+	_, err := io.WriteString(stdout, strings.ToUpper(script))
+	return 0, err
+}
+
+func TestFeatures(t *testing.T) {
+	filename := "featureexercise.md"
+	doc, err := testmark.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("spec file parse failed?!: %s", err)
+	}
+
+	doc.BuildDirIndex()
+	patches := testmark.PatchAccumulator{}
+	for _, dir := range doc.DirEnt.ChildrenList {
+		t.Run(dir.Name, func(t *testing.T) {
+			fc, exists := featureCases[dir.Name]
+			if !exists {
+				t.Fatalf("no featureCase for %q", dir.Name)
+			}
+			test := fc.tester
+			test.Patches = &patches
+			if fc.setup != nil {
+				fc.setup(t, &test)
+			}
+			test.Test(t, dir)
+		})
+	}
+	patches.WriteFileWithPatches(doc, filename)
+}
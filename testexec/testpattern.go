@@ -27,6 +27,7 @@ func (st SuiteTester) Run(
 	st.tcfg.reportUse = reportUse
 	st.tcfg.reportUnrecog = reportUnrecog
 	st.tcfg.Patches = patchAccum
+	st.tcfg.Filename = filename
 	st.tcfg.Test(t, subject)
 	return nil
 }
@@ -0,0 +1,171 @@
+package testexec
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The wildcard syntax, which is usable in expected output hunks if Tester.Wildcards is set:
+//
+//   - `[..]` within a line matches any text (within that line).
+//   - `...` on a line by itself matches any number of lines (including none).
+//   - `{{re:PATTERN}}` within a line matches the regular expression PATTERN (within that line).
+//
+// Everything else on a line is matched literally.
+var (
+	sigilWildcardText  = "[..]"
+	sigilWildcardLines = "..."
+	wildcardRegexp     = regexp.MustCompile(`\{\{re:(.*?)\}\}`)
+)
+
+// hasWildcards is true if any line of the body uses wildcard syntax.
+func hasWildcards(body []byte) bool {
+	for _, line := range strings.Split(string(body), "\n") {
+		if line == sigilWildcardLines || strings.Contains(line, sigilWildcardText) || wildcardRegexp.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardLine is one line of an expected body, ready for matching.
+type wildcardLine struct {
+	text     string
+	anyLines bool           // True if this is a `...` line.
+	pattern  *regexp.Regexp // Nil if this line is purely literal.
+}
+
+func (wl wildcardLine) matches(line string) bool {
+	if wl.pattern != nil {
+		return wl.pattern.MatchString(line)
+	}
+	return wl.text == line
+}
+
+func compileWildcards(expect string) ([]wildcardLine, error) {
+	var result []wildcardLine
+	for i, line := range strings.Split(expect, "\n") {
+		wl := wildcardLine{text: line}
+		switch {
+		case line == sigilWildcardLines:
+			wl.anyLines = true
+		case strings.Contains(line, sigilWildcardText) || wildcardRegexp.MatchString(line):
+			var sb strings.Builder
+			sb.WriteString("^")
+			rest := line
+			for len(rest) > 0 {
+				loc := wildcardRegexp.FindStringSubmatchIndex(rest)
+				textIdx := strings.Index(rest, sigilWildcardText)
+				switch {
+				case loc != nil && (textIdx < 0 || loc[0] < textIdx):
+					sb.WriteString(regexp.QuoteMeta(rest[:loc[0]]))
+					sb.WriteString("(?:" + rest[loc[2]:loc[3]] + ")")
+					rest = rest[loc[1]:]
+				case textIdx >= 0:
+					sb.WriteString(regexp.QuoteMeta(rest[:textIdx]))
+					sb.WriteString(".*")
+					rest = rest[textIdx+len(sigilWildcardText):]
+				default:
+					sb.WriteString(regexp.QuoteMeta(rest))
+					rest = ""
+				}
+			}
+			sb.WriteString("$")
+			re, err := regexp.Compile(sb.String())
+			if err != nil {
+				return nil, fmt.Errorf("line %d of expected output has an invalid pattern: %w", i+1, err)
+			}
+			wl.pattern = re
+		}
+		result = append(result, wl)
+	}
+	return result, nil
+}
+
+// mergeWildcards lines up actual output against an expected body that may contain wildcards.
+// It returns what the expected body should become to match the actual output,
+// keeping every wildcard (and every literal line) that still matches, and only replacing the lines that really differ.
+//
+// If the actual output matches, the result is exactly the expected body.
+// This means the result can be used both for regen (as the new body), and for asserting (as the "actual" value),
+// in which case a diff will show only the lines that failed to match.
+func mergeWildcards(expect, actual string) (string, error) {
+	want, err := compileWildcards(expect)
+	if err != nil {
+		return "", err
+	}
+	have := strings.Split(actual, "\n")
+
+	// Find the cheapest alignment by dynamic programming.
+	// Dropping, adding, or replacing a line each cost one; matching a line, or absorbing lines into a `...`, is free.
+	// cost[i][j] is the cost of aligning want[i:] with have[j:].
+	n, m := len(want), len(have)
+	cost := make([][]int, n+1)
+	for i := range cost {
+		cost[i] = make([]int, m+1)
+	}
+	for i := n; i >= 0; i-- {
+		for j := m; j >= 0; j-- {
+			switch {
+			case i == n:
+				cost[i][j] = m - j
+			case want[i].anyLines:
+				best := cost[i+1][j]
+				if j < m && cost[i][j+1] < best {
+					best = cost[i][j+1]
+				}
+				cost[i][j] = best
+			default:
+				best := 1 + cost[i+1][j]
+				if j < m {
+					if 1+cost[i][j+1] < best {
+						best = 1 + cost[i][j+1]
+					}
+					if 1+cost[i+1][j+1] < best {
+						best = 1 + cost[i+1][j+1]
+					}
+					if want[i].matches(have[j]) && cost[i+1][j+1] < best {
+						best = cost[i+1][j+1]
+					}
+				}
+				cost[i][j] = best
+			}
+		}
+	}
+	if cost[0][0] == 0 {
+		return expect, nil
+	}
+
+	// Walk the alignment, keeping matched expected lines, dropping unmatched ones, and taking in unmatched actual lines.
+	var result []string
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i == n:
+			result = append(result, have[j])
+			j++
+		case want[i].anyLines:
+			// Only absorb lines into the `...` if leaving it now would cost more;
+			// otherwise a changed line right after the `...` would vanish into it instead of showing up as a change.
+			if j < m && cost[i+1][j] > cost[i][j] {
+				j++
+			} else {
+				result = append(result, want[i].text)
+				i++
+			}
+		case j < m && want[i].matches(have[j]) && cost[i+1][j+1] == cost[i][j]:
+			result = append(result, want[i].text)
+			i, j = i+1, j+1
+		case j < m && 1+cost[i+1][j+1] == cost[i][j]:
+			result = append(result, have[j]) // This expected line is replaced by the actual one.
+			i, j = i+1, j+1
+		case j < m && 1+cost[i][j+1] == cost[i][j]:
+			result = append(result, have[j])
+			j++
+		default:
+			i++ // This expected line didn't match anything; drop it.
+		}
+	}
+	return strings.Join(result, "\n"), nil
+}
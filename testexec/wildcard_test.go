@@ -0,0 +1,39 @@
+package testexec
+
+import (
+	"testing"
+)
+
+func TestMergeWildcards(t *testing.T) {
+	for _, tc := range []struct {
+		expect string
+		actual string
+		merged string
+	}{
+		// Matching output leaves the expected body as it was.
+		{"built in [..]s\n", "built in 3.2s\n", "built in [..]s\n"},
+		{"start\n...\nend\n", "start\na\nb\nend\n", "start\n...\nend\n"},
+		{"start\n...\nend\n", "start\nend\n", "start\n...\nend\n"},
+		{"{{re:^v\\d+\\.\\d+$}}\n", "v1.23\n", "{{re:^v\\d+\\.\\d+$}}\n"},
+		{"path: {{re:/tmp/[a-z0-9]+}}/x [..]\n", "path: /tmp/abc123/x yes\n", "path: {{re:/tmp/[a-z0-9]+}}/x [..]\n"},
+
+		// Only lines that really differ get replaced; wildcards that still match stay.
+		{"built in [..]s\nresult: ok\n", "built in 3.2s\nresult: fail\n", "built in [..]s\nresult: fail\n"},
+		{"head\n...\nresult: ok\n", "head\nnoise\nmore noise\nresult: fail\n", "head\n...\nresult: fail\n"},
+		{"{{re:^v\\d+$}}\n", "version one\n", "version one\n"},
+		{"a\n[..]b\nc\n", "a\nc\nd\n", "a\nc\nd\n"},
+	} {
+		merged, err := mergeWildcards(tc.expect, tc.actual)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if merged != tc.merged {
+			t.Errorf("expect %q vs actual %q:\n\texpected merge: %q\n\tactual merge:   %q", tc.expect, tc.actual, tc.merged, merged)
+		}
+	}
+
+	_, err := mergeWildcards("{{re:(}}\n", "x\n")
+	if err == nil {
+		t.Errorf("expected an error for an invalid regexp")
+	}
+}
@@ -73,6 +73,10 @@ type DirEnt struct {
 	// A hunk, or nil.
 	Hunk *Hunk
 
+	// The hunk's position in the document (as it appears in Document.DataHunks), or nil if Hunk is nil.
+	// (Hunk points to the Hunk inside this, so they're always in agreement.)
+	DocHunk *DocHunk
+
 	// Children, recursively.
 	Children     map[string]*DirEnt
 	ChildrenList []*DirEnt
@@ -0,0 +1,115 @@
+package testmark
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// UpdateFile reads the current content of a file, hands the parsed Document to an update function,
+// and writes whatever document that function returns back to the file.
+// If the update function returns nil (or the same document it was given), nothing is written.
+//
+// This is meant for use when more than one process (or more than one test) might be regenerating the same file:
+// the update happens while holding an advisory lock on the file (flock, on platforms that have it),
+// and the file is re-read under that lock, so the update function always sees the latest content
+// (including changes made by anyone else who got the lock first).
+// The write itself is atomic: a temp file is written in the same directory, then renamed over the original.
+//
+// Typically, the update function re-applies some patches, like this:
+//
+//	testmark.UpdateFile(filename, func(doc *testmark.Document) (*testmark.Document, error) {
+//		return testmark.Patch(doc, patches...), nil
+//	})
+//
+// (PatchAccumulator.UpdateFile does exactly that.)
+func UpdateFile(filename string, update func(*Document) (*Document, error)) error {
+	f, err := openLocked(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // Also releases the lock.
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	doc, err := Read(f)
+	if err != nil {
+		return fmt.Errorf("could not parse %q: %w", filename, err)
+	}
+	newDoc, err := update(doc)
+	if err != nil {
+		return err
+	}
+	if newDoc == nil || newDoc == doc {
+		return nil
+	}
+
+	// Write to a temp file next to the original, then rename it into place.
+	// The lock is still held on the original inode until we're done, so anyone else waiting on it
+	// will notice the file was replaced, and go after the new one.
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Harmless if the rename already happened.
+	if _, err := Write(newDoc, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// openLocked opens a file and takes an exclusive advisory lock on it.
+// Because UpdateFile replaces files by renaming, after getting the lock we have to check
+// that the file we locked is still the one at that path; if it isn't, we try again.
+func openLocked(filename string) (*os.File, error) {
+	for {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockFile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("could not lock %q: %w", filename, err)
+		}
+		locked, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		current, err := os.Stat(filename)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if os.SameFile(locked, current) {
+			return f, nil
+		}
+		f.Close()
+	}
+}
+
+// UpdateFile re-reads the named file and applies the accumulated patches to its current content,
+// while holding a lock on it.  See the UpdateFile function for details.
+//
+// This is preferable to WriteFileWithPatches if anything else could be regenerating the same file at the same time,
+// because WriteFileWithPatches writes out the document it was given, discarding any changes made to the file since it was parsed.
+func (pa *PatchAccumulator) UpdateFile(filename string) error {
+	patches := pa.snapshot()
+	if len(patches) == 0 {
+		return nil
+	}
+	return UpdateFile(filename, func(doc *Document) (*Document, error) {
+		return Patch(doc, patches...), nil
+	})
+}
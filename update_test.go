@@ -0,0 +1,47 @@
+package testmark_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/warpfork/go-testmark"
+)
+
+func TestUpdateFileConcurrently(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "doc.md")
+	var original []byte
+	for i := 0; i < 10; i++ {
+		original = append(original, fmt.Sprintf("[testmark]:# (hunk-%d)\n```\nold\n```\n\n", i)...)
+	}
+	if err := ioutil.WriteFile(filename, original, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each writer has its own patch for a different hunk.
+	// If any of them wrote back a stale copy of the document, someone else's patch would get lost.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pa := testmark.PatchAccumulator{}
+			pa.AppendPatch(testmark.Hunk{Name: fmt.Sprintf("hunk-%d", i), Body: []byte(fmt.Sprintf("new %d\n", i))})
+			if err := pa.UpdateFile(filename); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	doc, err := testmark.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		assert(t, doc.HunksByName[fmt.Sprintf("hunk-%d", i)].Body, fmt.Sprintf("new %d\n", i))
+	}
+}
@@ -55,10 +55,10 @@ func WriteFileWithPatches(doc *Document, filename string, patches ...Hunk) error
 	return WriteFile(doc, filename)
 }
 
-func (pa PatchAccumulator) WriteWithPatches(doc *Document, wr io.Writer) (int, error) {
-	return WriteWithPatches(doc, wr, pa.Patches...)
+func (pa *PatchAccumulator) WriteWithPatches(doc *Document, wr io.Writer) (int, error) {
+	return WriteWithPatches(doc, wr, pa.snapshot()...)
 }
 
-func (pa PatchAccumulator) WriteFileWithPatches(doc *Document, filename string) error {
-	return WriteFileWithPatches(doc, filename, pa.Patches...)
+func (pa *PatchAccumulator) WriteFileWithPatches(doc *Document, filename string) error {
+	return WriteFileWithPatches(doc, filename, pa.snapshot()...)
 }